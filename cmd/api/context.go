@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/emmasela/greenlight/internal/data"
+)
+
+// contextKey is used for values stored in the request context to avoid collisions with other packages
+type contextKey string
+
+const (
+	userContextKey      = contextKey("user")
+	requestIDContextKey = contextKey("request_id")
+	routeContextKey     = contextKey("route")
+)
+
+// contextSetUser() returns a copy of the request with the given user added to its context.
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser() retrieves the user from the request context. It is only ever called when a user
+// is expected to be present, so a missing value is treated as a programming error and panics.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// contextSetRequestID() returns a copy of the request with the given request ID added to its context.
+func (app *application) contextSetRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID() retrieves the request ID from the request context, returning an empty
+// string if none has been set.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// contextSetActor() returns a copy of the request whose context attributes any changes made
+// through the models to the given user and the current request ID, for the movie audit trail.
+func (app *application) contextSetActor(r *http.Request, user *data.User) *http.Request {
+	actor := data.Actor{RequestID: app.contextGetRequestID(r)}
+
+	if !user.IsAnonymous() {
+		actor.UserID = user.ID
+	}
+
+	return r.WithContext(data.ContextWithActor(r.Context(), actor))
+}
+
+// contextWithRoute() returns a copy of the request with somewhere in its context for the matched
+// route's pattern to be recorded. The route is only known once the router has dispatched the
+// request, so middleware further out needs a shared slot to read it from afterwards.
+func (app *application) contextWithRoute(r *http.Request) *http.Request {
+	route := "unmatched"
+	ctx := context.WithValue(r.Context(), routeContextKey, &route)
+	return r.WithContext(ctx)
+}
+
+// contextSetRoute() records the pattern of the route matching the request, if the request context
+// has somewhere to record it.
+func (app *application) contextSetRoute(r *http.Request, pattern string) {
+	if route, ok := r.Context().Value(routeContextKey).(*string); ok {
+		*route = pattern
+	}
+}
+
+// contextGetRoute() retrieves the pattern of the route matching the request, returning
+// "unmatched" if the request didn't match any route.
+func (app *application) contextGetRoute(r *http.Request) string {
+	if route, ok := r.Context().Value(routeContextKey).(*string); ok {
+		return *route
+	}
+
+	return "unmatched"
+}
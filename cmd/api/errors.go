@@ -3,21 +3,59 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+
+	"github.com/emmasela/greenlight/internal/data"
+	"github.com/emmasela/greenlight/internal/validator"
 )
 
-// logError() logs an error message using the application's logger.
+// logError() logs an error message using the application's logger, along with the
+// request method and URL and, when available, the request ID and authenticated user ID.
 // This method is typically called when an internal error occurs that
 // needs to be recorded in the server logs.
-func (app *application) logError(_ *http.Request, err error) {
-	app.logger.Println(err)
+func (app *application) logError(r *http.Request, err error) {
+	properties := map[string]string{
+		"request_method": r.Method,
+		"request_url":    r.URL.String(),
+	}
+
+	if requestID := app.contextGetRequestID(r); requestID != "" {
+		properties["request_id"] = requestID
+	}
+
+	// The user may not be in the context yet (e.g. if authentication itself failed)
+	if user, ok := r.Context().Value(userContextKey).(*data.User); ok && !user.IsAnonymous() {
+		properties["user_id"] = strconv.FormatInt(user.ID, 10)
+	}
+
+	app.logger.PrintError(err, properties)
 }
 
 // errorResponse() sends a JSON-formatted error message and a specified HTTP status code
-// to the client. If there's an issue writing the JSON response, it logs the error
-// and sends a 500 Internal Server Error status.
+// to the client, along with the request ID so that failures can be traced in the logs.
+// Clients which prefer it receive an RFC 7807 problem details document instead of the
+// legacy {"error": ...} envelope. If there's an issue writing the JSON response, it logs
+// the error and sends a 500 Internal Server Error status.
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
+	if app.wantsProblemJSON(r) {
+		err := app.problemResponse(w, r, status, message)
+		if err != nil {
+			app.logError(r, err)
+			w.WriteHeader(500)
+		}
+		return
+	}
+
+	if v, ok := message.(*validator.Validator); ok {
+		message = v.Errors
+	}
+
 	env := envelope{"error": message}
 
+	if requestID := app.contextGetRequestID(r); requestID != "" {
+		env["request_id"] = requestID
+	}
+
 	// Write the JSON response. Logs error and return empty response with status code 500 if any
 	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
@@ -55,7 +93,69 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
-// failedValidationResponse() sends a 422 Unprocessable Entity Response when request body fails a validation check
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+// failedValidationResponse() sends a 422 Unprocessable Entity Response when request body fails a validation check.
+// Legacy responses carry the first error message for each field, while problem details responses
+// list every error along with its code and parameters.
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, v)
+}
+
+// editConflictResponse() sends a 409 Conflict response when a record could not be updated because
+// it was modified by another request in the meantime.
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// unsupportedMediaTypeResponse() sends a 415 Unsupported Media Type response when the request body
+// is in a format the endpoint does not understand.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %q content type is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// invalidCredentialsResponse() sends a 401 Unauthorized response when the supplied email or
+// password is incorrect.
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// invalidAuthenticationTokenResponse() sends a 401 Unauthorized response with a WWW-Authenticate
+// header when the bearer token in the request is missing, malformed, unknown or expired.
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// authenticationRequiredResponse() sends a 401 Unauthorized response with a WWW-Authenticate header
+// when an anonymous client tries to access a resource that requires authentication.
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// inactiveAccountResponse() sends a 403 Forbidden response when a user who has not yet activated
+// their account tries to access a resource that requires activation.
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// notPermittedResponse() sends a 403 Forbidden response when a user lacks the permission needed
+// to access a resource.
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// rateLimitExceededResponse() sends a 429 Too Many Requests response when a client has exceeded
+// its request rate limit.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
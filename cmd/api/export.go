@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emmasela/greenlight/internal/data"
+	"github.com/emmasela/greenlight/internal/validator"
+)
+
+// exportFormat describes how movies are written in one of the export formats
+type exportFormat struct {
+	contentType string
+	extension   string
+}
+
+var exportFormats = map[string]exportFormat{
+	"ndjson": {contentType: "application/x-ndjson", extension: "ndjson"},
+	"csv":    {contentType: "text/csv; charset=utf-8", extension: "csv"},
+	"json":   {contentType: "application/json", extension: "json"},
+}
+
+// exportMoviesHandler() streams every movie matching the same title, genres, q and sort filters as
+// listMoviesHandler() as a downloadable file. The format query string parameter selects NDJSON
+// (the default), CSV or a JSON array, and the file is gzipped if the client accepts it. Movies are
+// written as they are read from the database, so the export is never held in memory as a whole.
+// Like listing, exporting requires the movies:read permission.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	title := app.readString(qs, "title", "")
+	genres := app.readCSV(qs, "genres", []string{})
+	search := app.readString(qs, "q", "")
+
+	defaultSort := "id"
+	if search != "" {
+		defaultSort = "-relevance"
+	}
+
+	// Exports aren't paginated, so only the sort filter applies
+	filters := data.Filters{
+		Sort: app.readString(qs, "sort", defaultSort),
+		SortSafelist: []string{
+			"id", "title", "year", "runtime", "relevance",
+			"-id", "-title", "-year", "-runtime", "-relevance",
+		},
+	}
+
+	v.CheckCode(validator.In(filters.Sort, filters.SortSafelist...), "sort", validator.CodeInvalid, "invalid sort value",
+		map[string]interface{}{"allowed": filters.SortSafelist})
+
+	formatName := app.readString(qs, "format", "ndjson")
+	format, ok := exportFormats[formatName]
+	v.CheckCode(ok, "format", validator.CodeInvalid, "must be one of: ndjson, csv, json",
+		map[string]interface{}{"allowed": []string{"ndjson", "csv", "json"}})
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	runtimeFormat, err := app.readRuntimeFormat(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Large exports take far longer to send than the server's usual write timeout allows
+	err = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(app.config.exports.timeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var (
+		out     *bufio.Writer
+		gz      *gzip.Writer
+		csvw    *csv.Writer
+		enc     *json.Encoder
+		started bool
+		count   int
+	)
+
+	// start writes the headers and anything which precedes the first movie. It is deferred until
+	// the first movie has been read, so that errors from starting the export can still be sent
+	// as a normal error response.
+	start := func() error {
+		started = true
+
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="movies-%s.%s"`,
+			time.Now().UTC().Format("20060102"), format.extension))
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		var dst io.Writer = w
+
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz = gzip.NewWriter(w)
+			dst = gz
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+		out = bufio.NewWriter(dst)
+		enc = json.NewEncoder(out)
+
+		switch formatName {
+		case "csv":
+			csvw = csv.NewWriter(out)
+			return csvw.Write([]string{"id", "title", "year", "runtime", "genres", "version"})
+		case "json":
+			_, err := out.WriteString("[\n")
+			return err
+		}
+
+		return nil
+	}
+
+	err = app.models.Movies.Export(r.Context(), title, genres, search, filters, app.config.exports.batchSize, func(movie *data.Movie) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		movie.RuntimeFormat = runtimeFormat
+		count++
+
+		switch formatName {
+		case "csv":
+			return csvw.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.Itoa(int(movie.Year)),
+				fmt.Sprint(movie.Runtime.Format(runtimeFormat)),
+				strings.Join(movie.Genres, ","),
+				strconv.Itoa(int(movie.Version)),
+			})
+		case "json":
+			if count > 1 {
+				if _, err := out.WriteString(",\n"); err != nil {
+					return err
+				}
+			}
+			return enc.Encode(movie)
+		default:
+			return enc.Encode(movie)
+		}
+	})
+
+	if err == nil && !started {
+		err = start()
+	}
+
+	if err == nil {
+		err = finishExport(formatName, out, csvw, gz)
+	}
+
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// The response is already under way, so the best that can be done is to log the error and
+		// abort the connection, letting the client see that the export is incomplete
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// finishExport() writes whatever follows the last movie and flushes the buffered writers.
+func finishExport(formatName string, out *bufio.Writer, csvw *csv.Writer, gz *gzip.Writer) error {
+	switch formatName {
+	case "csv":
+		csvw.Flush()
+		if err := csvw.Error(); err != nil {
+			return err
+		}
+	case "json":
+		if _, err := out.WriteString("]\n"); err != nil {
+			return err
+		}
+	}
+
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	if gz != nil {
+		return gz.Close()
+	}
+
+	return nil
+}
+
+// acceptsGzip() reports whether the request's Accept-Encoding header allows a gzipped response
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		// A quality value of zero means the coding is not acceptable
+		if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if quality, err := strconv.ParseFloat(q, 64); err == nil && quality == 0 {
+				continue
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
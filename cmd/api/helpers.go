@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/emmasela/greenlight/internal/data"
+	"github.com/emmasela/greenlight/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -27,9 +30,33 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readExpectedVersion() reads the version a client expects a record to be at from the If-Match or
+// X-Expected-Version header. The If-Match value may be given as an entity tag (e.g. "3" or W/"3").
+// It returns ok == false if neither header is present.
+func (app *application) readExpectedVersion(r *http.Request) (version int32, ok bool, err error) {
+	value := r.Header.Get("X-Expected-Version")
+	if value == "" {
+		value = r.Header.Get("If-Match")
+		value = strings.TrimPrefix(value, "W/")
+		value = strings.Trim(value, `"`)
+	}
+
+	if value == "" {
+		return 0, false, nil
+	}
+
+	i, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || i < 1 {
+		return 0, false, errors.New("invalid expected version header")
+	}
+
+	return int32(i), true, nil
+}
+
 // writeJSON() sends a JSON response to the client. It encodes the given data to JSON,
 // sets the "Content-Type: application/json" header, writes the provided HTTP status code,
-// and adds any additional headers. Returns an error if JSON encoding fails.
+// and adds any additional headers (which may override the Content-Type). Returns an error
+// if JSON encoding fails.
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	// Encode the data to JSON, returning error if any
 	js, err := json.Marshal(data)
@@ -40,14 +67,15 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	// Append a newline to make it easier to view in terminal applications.
 	js = append(js, '\n')
 
-	// Set response headers from the provided headers map
+	// Add the "Content-Type: application/json" header, then set response headers from the
+	// provided headers map
+	w.Header().Set("Content-Type", "application/json")
+
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
 
-	// Add the "Content-Type: application/json" header, then write the status code and
-	// JSON response.
-	w.Header().Set("Content-Type", "application/json")
+	// Write the status code and JSON response.
 	w.WriteHeader(status)
 	_, err = w.Write(js)
 	if err != nil {
@@ -124,3 +152,81 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 
 	return nil
 }
+
+// readRuntimeFormat() reads the format movie runtimes should be rendered in from the runtime_format
+// query string parameter, defaulting to the "102 mins" format.
+func (app *application) readRuntimeFormat(r *http.Request) (data.RuntimeFormat, error) {
+	format := r.URL.Query().Get("runtime_format")
+	if format == "" {
+		return data.RuntimeFormatMinutes, nil
+	}
+
+	runtimeFormat, err := data.ParseRuntimeFormat(format)
+	if err != nil {
+		return "", fmt.Errorf("runtime_format must be one of: %s", strings.Join(data.RuntimeFormats, ", "))
+	}
+
+	return runtimeFormat, nil
+}
+
+// readString() returns a string value from the query string, or the provided default value
+// if no matching key could be found.
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	return s
+}
+
+// readCSV() reads a comma-separated string value from the query string and splits it into a
+// slice. If no matching key could be found, it returns the provided default value.
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+
+	if csv == "" {
+		return defaultValue
+	}
+
+	return strings.Split(csv, ",")
+}
+
+// readInt() reads a string value from the query string and converts it to an integer. If no
+// matching key could be found it returns the default value. If the value couldn't be converted
+// to an integer, the error is recorded in the provided Validator instance.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddFieldError(key, validator.CodeInvalidType, "must be an integer value", map[string]interface{}{"type": "integer"})
+		return defaultValue
+	}
+
+	return i
+}
+
+// background() runs the given function in a new goroutine, recovering and logging any panic
+// so that it cannot bring down the whole server. The goroutine is tracked by the application's
+// WaitGroup so that graceful shutdown can wait for it to complete.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
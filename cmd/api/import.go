@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emmasela/greenlight/internal/data"
+	"github.com/emmasela/greenlight/internal/validator"
+)
+
+// The import modes: all-or-nothing, or insert whichever rows are valid
+const (
+	importModeAtomic     = "atomic"
+	importModeBestEffort = "best_effort"
+)
+
+// maxImportLineBytes bounds the length of a single NDJSON line, in line with the readJSON() limit
+const maxImportLineBytes = 1_048_576
+
+// The statuses of a row in the import report
+const (
+	importRowAccepted = "accepted"
+	importRowRejected = "rejected"
+	importRowFailed   = "failed"
+)
+
+// importRow is the outcome of importing a single row, as included in the import report. Errors
+// has the same shape as the errors of a failed validation response.
+type importRow struct {
+	Row    int                 `json:"row"`
+	Status string              `json:"status"`
+	ID     int64               `json:"id,omitempty"`
+	Errors []problemFieldError `json:"errors,omitempty"`
+}
+
+// movieReader reads the movies to import one row at a time. Next() returns the movie in the next
+// row, or a validator holding the problems with the row if it can't be parsed. It returns io.EOF
+// once all rows have been read, and any other error if the body as a whole can't be read.
+type movieReader interface {
+	Next() (*data.Movie, *validator.Validator, error)
+}
+
+// importMoviesHandler() bulk imports movies from a CSV (text/csv) or NDJSON (application/x-ndjson)
+// body, which is streamed rather than read into memory. Valid rows are inserted in batches. In the
+// default atomic mode the import is all-or-nothing, whereas mode=best_effort inserts every valid
+// row. With dry_run=true the rows are only validated. The response reports the outcome of each row.
+//
+// A best-effort import commits each batch as it goes. If a batch then fails to insert, or the rest
+// of the body can't be read, the import stops there and a 207 Multi-Status response reports the
+// rows which were saved (and the rows of a failed batch as failed), so the client knows what not
+// to send again.
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	mode := app.readString(qs, "mode", importModeAtomic)
+	v.CheckCode(validator.In(mode, importModeAtomic, importModeBestEffort), "mode", validator.CodeInvalid,
+		"must be one of: atomic, best_effort", map[string]interface{}{"allowed": []string{importModeAtomic, importModeBestEffort}})
+
+	dryRun, err := strconv.ParseBool(app.readString(qs, "dry_run", "false"))
+	v.CheckCode(err == nil, "dry_run", validator.CodeInvalidType, "must be a boolean value",
+		map[string]interface{}{"type": "boolean"})
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Imports are allowed a much larger body than other endpoints, and more time to send it
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.imports.maxBytes)
+
+	deadline := time.Now().Add(app.config.imports.timeout)
+	rc := http.NewResponseController(w)
+
+	err = errors.Join(rc.SetReadDeadline(deadline), rc.SetWriteDeadline(deadline))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var reader movieReader
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "text/csv":
+		reader, err = newCSVMovieReader(r.Body)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	case "application/x-ndjson", "application/ndjson":
+		reader = newNDJSONMovieReader(r.Body)
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	var imp *data.MovieImport
+
+	if !dryRun {
+		imp, err = app.models.Movies.NewImport(r.Context(), mode == importModeAtomic)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		defer imp.Rollback(r.Context())
+	}
+
+	var (
+		report   []importRow
+		batch    []*data.Movie
+		rejected int
+		failed   int
+	)
+
+	// flush inserts the pending batch, recording the IDs of the movies against their rows, or
+	// marking the rows as failed if the batch can't be inserted. Once an atomic import has
+	// rejected a row there is no point inserting anything further.
+	flush := func() error {
+		defer func() { batch = batch[:0] }()
+
+		if imp == nil || (mode == importModeAtomic && rejected > 0) {
+			return nil
+		}
+
+		first := len(report) - len(batch)
+
+		err := imp.Insert(r.Context(), batch)
+		if err != nil {
+			for i := first; i < len(report); i++ {
+				report[i].Status = importRowFailed
+			}
+			failed += len(batch)
+			return err
+		}
+
+		for i, movie := range batch {
+			report[first+i].ID = movie.ID
+		}
+
+		return nil
+	}
+
+	// insertErr and readErr are the errors which stopped an import part way through, either by
+	// failing to insert a batch or to read the rest of the body
+	var insertErr, readErr error
+
+	for row := 1; ; row++ {
+		movie, problems, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+
+			if errors.As(err, &maxBytesError) {
+				err = fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			}
+
+			readErr = err
+			break
+		}
+
+		if problems == nil {
+			v := validator.New()
+
+			if data.ValidateMovie(v, movie); !v.Valid() {
+				problems = v
+			}
+		}
+
+		if problems != nil {
+			// Rejected rows are reported straight away, so insert the batch first to keep the
+			// report in row order
+			if insertErr = flush(); insertErr != nil {
+				break
+			}
+
+			rejected++
+			report = append(report, importRow{Row: row, Status: importRowRejected, Errors: problemFieldErrors(problems)})
+			continue
+		}
+
+		report = append(report, importRow{Row: row, Status: importRowAccepted})
+		batch = append(batch, movie)
+
+		if len(batch) >= app.config.imports.batchSize {
+			if insertErr = flush(); insertErr != nil {
+				break
+			}
+		}
+	}
+
+	// Nothing has been committed by a dry run or an atomic import, so a body which can't be read
+	// is simply a bad request
+	if readErr != nil && (dryRun || mode == importModeAtomic) {
+		app.badRequestResponse(w, r, readErr)
+		return
+	}
+
+	// A best-effort import saves the rows read before a read error, like any others
+	if insertErr == nil {
+		insertErr = flush()
+	}
+
+	if insertErr != nil && mode == importModeAtomic {
+		app.serverErrorResponse(w, r, insertErr)
+		return
+	}
+
+	saved := len(report) - rejected - failed
+
+	if readErr != nil && insertErr == nil && saved == 0 {
+		app.badRequestResponse(w, r, readErr)
+		return
+	}
+
+	status := http.StatusOK
+	committed := false
+
+	switch {
+	case dryRun:
+	case insertErr != nil, readErr != nil:
+		// The batches before the error have already been committed, so report what was saved
+		// rather than a bare error response
+		if insertErr != nil {
+			app.logError(r, insertErr)
+		}
+		status = http.StatusMultiStatus
+		committed = saved > 0
+	case mode == importModeAtomic && rejected > 0:
+		// Nothing was written, so drop the IDs handed out to the rows before the first rejection
+		for i := range report {
+			report[i].ID = 0
+		}
+		status = http.StatusUnprocessableEntity
+	default:
+		err = imp.Commit(r.Context())
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		committed = true
+	}
+
+	if report == nil {
+		report = []importRow{}
+	}
+
+	summary := envelope{
+		"mode":      mode,
+		"dry_run":   dryRun,
+		"committed": committed,
+		"total":     len(report),
+		"accepted":  saved,
+		"rejected":  rejected,
+		"failed":    failed,
+		"rows":      report,
+	}
+
+	switch {
+	case insertErr != nil:
+		summary["detail"] = "the import was stopped by a server error; the accepted rows before the failed ones have been saved, and no rows after them were read"
+	case readErr != nil:
+		summary["detail"] = fmt.Sprintf("the import was stopped because the body could not be read (%s); the accepted rows have been saved, and no rows after them were read", readErr)
+	}
+
+	err = app.writeJSON(w, status, envelope{"import": summary}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// csvMovieReader reads movies from CSV with a header row naming the title, year, runtime and
+// genres columns, in any order. Genres are comma separated within their (quoted) field.
+type csvMovieReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVMovieReader(body io.Reader) (*csvMovieReader, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, fmt.Errorf("body contains an invalid CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if !validator.In(name, "title", "year", "runtime", "genres") {
+			return nil, fmt.Errorf("body contains unknown column %q", name)
+		}
+
+		columns[name] = i
+	}
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("body is missing the %q column", name)
+		}
+	}
+
+	return &csvMovieReader{reader: reader, columns: columns}, nil
+}
+
+func (cr *csvMovieReader) Next() (*data.Movie, *validator.Validator, error) {
+	record, err := cr.reader.Read()
+	if err != nil {
+		// A malformed row only affects that row, so it is reported rather than ending the import
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			return nil, rowProblem(validator.CodeFormat, parseError.Err.Error(), map[string]interface{}{"format": "csv"}), nil
+		}
+		return nil, nil, err
+	}
+
+	movie := &data.Movie{Title: strings.TrimSpace(record[cr.columns["title"]])}
+	problems := validator.New()
+
+	if year := strings.TrimSpace(record[cr.columns["year"]]); year != "" {
+		n, err := strconv.ParseInt(year, 10, 32)
+		if err != nil {
+			problems.AddFieldError("year", validator.CodeInvalidType, "must be an integer value",
+				map[string]interface{}{"type": "integer"})
+		}
+		movie.Year = int32(n)
+	}
+
+	if runtime := strings.TrimSpace(record[cr.columns["runtime"]]); runtime != "" {
+		movie.Runtime, err = data.ParseRuntime(runtime)
+		if err != nil {
+			problems.AddFieldError("runtime", validator.CodeFormat, err.Error(), map[string]interface{}{"format": "runtime"})
+		}
+	}
+
+	if genres := strings.TrimSpace(record[cr.columns["genres"]]); genres != "" {
+		for _, genre := range strings.Split(genres, ",") {
+			movie.Genres = append(movie.Genres, strings.TrimSpace(genre))
+		}
+	}
+
+	if !problems.Valid() {
+		return nil, problems, nil
+	}
+
+	return movie, nil, nil
+}
+
+// ndjsonMovieReader reads movies from newline delimited JSON, one movie object per line, in the
+// same form as accepted by createMovieHandler(). Blank lines are skipped.
+type ndjsonMovieReader struct {
+	scanner *bufio.Scanner
+}
+
+func newNDJSONMovieReader(body io.Reader) *ndjsonMovieReader {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineBytes)
+
+	return &ndjsonMovieReader{scanner: scanner}
+}
+
+func (nr *ndjsonMovieReader) Next() (*data.Movie, *validator.Validator, error) {
+	var line []byte
+
+	for len(line) == 0 {
+		if !nr.scanner.Scan() {
+			err := nr.scanner.Err()
+			switch {
+			case err == nil:
+				return nil, nil, io.EOF
+			case errors.Is(err, bufio.ErrTooLong):
+				return nil, nil, fmt.Errorf("body must not contain lines longer than %d bytes", maxImportLineBytes)
+			default:
+				return nil, nil, err
+			}
+		}
+
+		line = bytes.TrimSpace(nr.scanner.Bytes())
+	}
+
+	var input struct {
+		Title   string       `json:"title"`
+		Year    int32        `json:"year"`
+		Runtime data.Runtime `json:"runtime"`
+		Genres  []string     `json:"genres"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&input)
+	if err == nil && dec.More() {
+		err = errors.New("must only contain a single JSON value")
+	}
+
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		problems := validator.New()
+
+		switch {
+		case errors.As(err, &syntaxError), errors.Is(err, io.ErrUnexpectedEOF):
+			problems = rowProblem(validator.CodeFormat, "contains badly-formed JSON", map[string]interface{}{"format": "json"})
+		case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+			problems.AddFieldError(unmarshalTypeError.Field, validator.CodeInvalidType, "contains incorrect JSON type",
+				map[string]interface{}{"type": unmarshalTypeError.Type.String()})
+		case errors.Is(err, data.ErrInvalidRuntimeFormat):
+			problems.AddFieldError("runtime", validator.CodeFormat, err.Error(), map[string]interface{}{"format": "runtime"})
+		default:
+			problems = rowProblem(validator.CodeInvalid, strings.TrimPrefix(err.Error(), "json: "), nil)
+		}
+
+		return nil, problems, nil
+	}
+
+	movie := &data.Movie{
+		Title:   input.Title,
+		Year:    input.Year,
+		Runtime: input.Runtime,
+		Genres:  input.Genres,
+	}
+
+	return movie, nil, nil
+}
+
+// rowProblem() reports a problem with a row as a whole, rather than one of its fields
+func rowProblem(code, message string, params map[string]interface{}) *validator.Validator {
+	v := validator.New()
+	v.AddFieldError("row", code, message, params)
+	return v
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// startPurgeJob() launches a tracked background goroutine which periodically purges movies that
+// were soft deleted longer ago than the configured retention window. It stops when the server
+// shuts down. A zero retention window disables the job.
+func (app *application) startPurgeJob() {
+	if app.config.purge.retention <= 0 || app.config.purge.interval <= 0 {
+		return
+	}
+
+	app.background(func() {
+		ticker := time.NewTicker(app.config.purge.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.done:
+				return
+			case <-ticker.C:
+			}
+
+			cutoff := time.Now().Add(-app.config.purge.retention)
+
+			purged, err := app.models.Movies.PurgeDeletedBefore(context.Background(), cutoff)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+				continue
+			}
+
+			if purged > 0 {
+				app.logger.PrintInfo("purged soft deleted movies", map[string]string{
+					"count":  strconv.FormatInt(purged, 10),
+					"cutoff": cutoff.UTC().Format(time.RFC3339),
+				})
+			}
+		}
+	})
+}
@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
-	"fmt"
 	"log"
-	"net/http"
+	"net/netip"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/emmasela/greenlight/internal/data"
+	"github.com/emmasela/greenlight/internal/jsonlog"
+	"github.com/emmasela/greenlight/internal/mailer"
+	"github.com/emmasela/greenlight/internal/migrate"
+	"github.com/emmasela/greenlight/migrations"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 )
@@ -18,21 +24,66 @@ const version = "1.0.0"
 
 // Config struct to hold configuration settings
 type config struct {
-	port int
-	env  string
-	db   struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
+	port            int
+	env             string
+	errorFormat     string
+	shutdownTimeout time.Duration
+	db              struct {
+		dsn               string
+		maxOpenConns      int
+		minConns          int
+		maxIdleTime       time.Duration
+		maxConnLifetime   time.Duration
+		healthCheckPeriod time.Duration
+		queryTimeout      time.Duration
+	}
+	limiter struct {
+		rps            float64
+		burst          int
+		enabled        bool
+		trustedProxies []netip.Prefix
+	}
+	purge struct {
+		retention time.Duration
+		interval  time.Duration
+	}
+	imports struct {
+		maxBytes  int64
+		batchSize int
+		timeout   time.Duration
+	}
+	exports struct {
+		batchSize int
+		timeout   time.Duration
+	}
+	cors struct {
+		trustedOrigins []string
+		maxAge         time.Duration
+	}
+	metrics struct {
+		enabled  bool
+		username string
+		password string
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
 	}
 }
 
 // Application struct to hold dependencies
 type application struct {
 	config config
-	logger *log.Logger
+	logger *jsonlog.Logger
 	models data.Models
+	mailer mailer.Mailer
+	wg     sync.WaitGroup
+	// done is closed when the server starts shutting down, telling long-running background
+	// goroutines to stop so that the WaitGroup can drain
+	done chan struct{}
 }
 
 func main() {
@@ -46,47 +97,155 @@ func main() {
 	// Set the default values for the config
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&cfg.errorFormat, "error-format", "legacy", "Default error response format (legacy|problem)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Graceful shutdown grace period")
 	flag.StringVar(
 		&cfg.db.dsn, "db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PostgreSQL DSN",
 	)
 
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.IntVar(&cfg.db.minConns, "db-min-conns", 0, "PostgreSQL connections kept open even when idle")
+	// pgxpool has no idle connection limit, so the flag is only kept so existing deployments still start
+	flag.Int("db-max-idle-conns", 0, "Deprecated: has no effect, use -db-min-conns instead")
+	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.db.maxConnLifetime, "db-max-conn-lifetime", time.Hour, "PostgreSQL max connection lifetime")
+	flag.DurationVar(&cfg.db.healthCheckPeriod, "db-health-check-period", time.Minute, "PostgreSQL idle connection health check period")
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second, "PostgreSQL per-query timeout")
+
+	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+
+	// Only proxies listed here are trusted to report the client IP via X-Forwarded-For or X-Real-IP
+	flag.Func("limiter-trusted-proxies", "Trusted proxy IPs or CIDR ranges (comma separated)", func(val string) error {
+		for _, s := range strings.Split(val, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(s)
+				if addrErr != nil {
+					return err
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+
+			cfg.limiter.trustedProxies = append(cfg.limiter.trustedProxies, prefix.Masked())
+		}
+		return nil
+	})
+
+	flag.DurationVar(&cfg.purge.retention, "purge-retention", 30*24*time.Hour, "How long soft deleted movies are kept before being purged (0 disables purging)")
+	flag.DurationVar(&cfg.purge.interval, "purge-interval", time.Hour, "How often to purge soft deleted movies")
+
+	flag.Int64Var(&cfg.imports.maxBytes, "import-max-bytes", 100<<20, "Maximum size of a bulk movie import body")
+	flag.IntVar(&cfg.imports.batchSize, "import-batch-size", 1000, "Number of movies inserted per batch during bulk imports")
+	flag.DurationVar(&cfg.imports.timeout, "import-timeout", 5*time.Minute, "Time allowed to upload and process a bulk movie import")
+
+	flag.IntVar(&cfg.exports.batchSize, "export-batch-size", 500, "Number of movies fetched from the database at a time during exports")
+	flag.DurationVar(&cfg.exports.timeout, "export-timeout", 10*time.Minute, "Time allowed to send a bulk movie export")
+
+	flag.Func("cors-trusted-origins", "Trusted CORS origins (space or comma separated)", func(val string) error {
+		cfg.cors.trustedOrigins = strings.FieldsFunc(val, func(r rune) bool {
+			return r == ' ' || r == ','
+		})
+		return nil
+	})
+	flag.DurationVar(&cfg.cors.maxAge, "cors-max-age", time.Hour, "How long browsers may cache CORS preflight responses")
+
+	flag.BoolVar(&cfg.metrics.enabled, "metrics-enabled", false, "Expose /debug/vars and /metrics endpoints")
+	flag.StringVar(&cfg.metrics.username, "metrics-username", os.Getenv("GREENLIGHT_METRICS_USERNAME"), "Basic auth username for metrics endpoints")
+	flag.StringVar(&cfg.metrics.password, "metrics-password", os.Getenv("GREENLIGHT_METRICS_PASSWORD"), "Basic auth password for metrics endpoints")
+
+	// The SMTP defaults point at a local mail sink (e.g. Mailpit or MailHog) for development
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 1025, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("GREENLIGHT_SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("GREENLIGHT_SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.local>", "SMTP sender")
 
 	flag.Parse()
 
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	if cfg.errorFormat != "legacy" && cfg.errorFormat != "problem" {
+		logger.PrintFatal(errors.New("error-format must be either legacy or problem"), nil)
+	}
+
+	// A batch size of zero would make every import or export silently do nothing
+	if cfg.imports.batchSize < 1 || cfg.exports.batchSize < 1 {
+		logger.PrintFatal(errors.New("import-batch-size and export-batch-size must be at least 1"), nil)
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "db-max-idle-conns" {
+			logger.PrintInfo("the db-max-idle-conns flag is deprecated and has no effect, use db-min-conns instead", nil)
+		}
+	})
+
+	// The metrics expose operational details, so they are never served without authentication
+	if cfg.metrics.enabled && (cfg.metrics.username == "" || cfg.metrics.password == "") {
+		logger.PrintFatal(errors.New("metrics-username and metrics-password must be set when metrics are enabled"), nil)
+	}
 
 	db, err := openDB(cfg)
 	if err != nil {
-		logger.Fatal(err)
+		logger.PrintFatal(err, nil)
 	}
 
-	defer db.Close()
+	logger.PrintInfo("database connection pool established", nil)
+
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 
-	logger.Printf("database connection pool established")
+	// Run the "migrate" subcommand instead of the server if it was given
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "migrate" {
+			logger.PrintFatal(errors.New(migrateUsage), nil)
+		}
+
+		err = runMigrateCommand(migrator, logger, args[1:])
+		db.Close()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
+
+	// Refuse to serve requests against an outdated schema
+	err = checkSchemaVersion(migrator)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if cfg.metrics.enabled {
+		publishMetrics(db)
+	}
 
 	// Create a new application pointer and assign the config and logger
 	app := &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db),
+		models: data.NewModels(db, cfg.db.queryTimeout),
+		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		done:   make(chan struct{}),
 	}
 
-	// Declare an HTTP server
-	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
-	}
+	// Start the HTTP server and block until it has shut down
+	err = app.serve()
+
+	// serve() only returns once background tasks have finished, so the pool can now be closed
+	db.Close()
+	logger.PrintInfo("database connection pool closed", nil)
 
-	// Start HTTP server
-	logger.Printf("Starting %s server on port %s", cfg.env, server.Addr)
-	err = server.ListenAndServe()
-	logger.Fatal(err)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 }
 
 // openDB initializes a connection pool to the database using the provided configuration.
@@ -94,8 +253,21 @@ func openDB(cfg config) (*pgxpool.Pool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Create a new connection pool using the context and the database DSN from the configuration.
-	db, err := pgxpool.New(ctx, cfg.db.dsn)
+	poolConfig, err := pgxpool.ParseConfig(cfg.db.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// pgxpool has no notion of a maximum number of idle connections: idle connections above
+	// MinConns are closed once they have been idle for MaxConnIdleTime.
+	poolConfig.MaxConns = int32(cfg.db.maxOpenConns)
+	poolConfig.MinConns = int32(min(cfg.db.minConns, cfg.db.maxOpenConns))
+	poolConfig.MaxConnIdleTime = cfg.db.maxIdleTime
+	poolConfig.MaxConnLifetime = cfg.db.maxConnLifetime
+	poolConfig.HealthCheckPeriod = cfg.db.healthCheckPeriod
+
+	// Create a new connection pool using the context and the pool configuration.
+	db, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request duration histogram buckets
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// latencySeries holds the cumulative bucket counts, sum and count for a single route.
+type latencySeries struct {
+	Buckets []uint64 `json:"buckets"`
+	Sum     float64  `json:"sum"`
+	Count   uint64   `json:"count"`
+}
+
+// routeLatency is a histogram of request durations keyed by "<method> <route pattern>". It
+// implements expvar.Var so it can be published alongside the other metrics.
+type routeLatency struct {
+	mu     sync.Mutex
+	series map[string]*latencySeries
+}
+
+func newRouteLatency() *routeLatency {
+	return &routeLatency{series: make(map[string]*latencySeries)}
+}
+
+// Observe records a request duration against the given route
+func (l *routeLatency) Observe(route string, d time.Duration) {
+	seconds := d.Seconds()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.series[route]
+	if !ok {
+		s = &latencySeries{Buckets: make([]uint64, len(latencyBuckets))}
+		l.series[route] = s
+	}
+
+	for i, upperBound := range latencyBuckets {
+		if seconds <= upperBound {
+			s.Buckets[i]++
+		}
+	}
+
+	s.Sum += seconds
+	s.Count++
+}
+
+// Snapshot returns a copy of every series so it can be read without holding the lock
+func (l *routeLatency) Snapshot() map[string]latencySeries {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	snapshot := make(map[string]latencySeries, len(l.series))
+	for route, s := range l.series {
+		snapshot[route] = latencySeries{
+			Buckets: append([]uint64(nil), s.Buckets...),
+			Sum:     s.Sum,
+			Count:   s.Count,
+		}
+	}
+
+	return snapshot
+}
+
+// String implements expvar.Var
+func (l *routeLatency) String() string {
+	js, err := json.Marshal(l.Snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(js)
+}
+
+// publishMetrics() registers the process-wide expvar variables: the request counters used by the
+// metrics middleware, the goroutine count and the database connection pool statistics.
+func publishMetrics(db *pgxpool.Pool) {
+	expvar.NewInt("total_requests_received")
+	expvar.NewInt("total_responses_sent")
+	expvar.NewInt("in_flight_requests")
+	expvar.NewMap("total_responses_sent_by_status")
+	expvar.Publish("request_duration_seconds", newRouteLatency())
+
+	expvar.NewString("version").Set(version)
+
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+
+	expvar.Publish("database", expvar.Func(func() interface{} {
+		stat := db.Stat()
+		return map[string]interface{}{
+			"acquired_conns":           stat.AcquiredConns(),
+			"idle_conns":               stat.IdleConns(),
+			"total_conns":              stat.TotalConns(),
+			"max_conns":                stat.MaxConns(),
+			"acquire_count":            stat.AcquireCount(),
+			"empty_acquire_count":      stat.EmptyAcquireCount(),
+			"canceled_acquire_count":   stat.CanceledAcquireCount(),
+			"acquire_duration_seconds": stat.AcquireDuration().Seconds(),
+		}
+	}))
+
+	expvar.Publish("timestamp", expvar.Func(func() interface{} {
+		return time.Now().Unix()
+	}))
+}
+
+// metrics() updates the request counters, the per-status response totals and the per-route
+// latency histogram for every request. The variables must have been registered by publishMetrics().
+func (app *application) metrics(next http.Handler) http.Handler {
+	totalRequestsReceived := expvar.Get("total_requests_received").(*expvar.Int)
+	totalResponsesSent := expvar.Get("total_responses_sent").(*expvar.Int)
+	inFlightRequests := expvar.Get("in_flight_requests").(*expvar.Int)
+	totalResponsesSentByStatus := expvar.Get("total_responses_sent_by_status").(*expvar.Map)
+	requestDuration := expvar.Get("request_duration_seconds").(*routeLatency)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		totalRequestsReceived.Add(1)
+		inFlightRequests.Add(1)
+		defer inFlightRequests.Add(-1)
+
+		rr := &responseRecorder{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+
+		// Recorded from a deferred function so that responses aborted with a panic are counted too
+		defer func() {
+			totalResponsesSent.Add(1)
+			totalResponsesSentByStatus.Add(strconv.Itoa(rr.statusCode), 1)
+			requestDuration.Observe(metricsMethod(r.Method)+" "+app.contextGetRoute(r), time.Since(start))
+		}()
+
+		next.ServeHTTP(rr, r)
+	})
+}
+
+// metricsMethod() returns the method to label a request's metrics with. Clients can send any method
+// they like, so anything other than the standard methods is labelled OTHER to keep the number of
+// histogram series bounded.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// prometheusHandler() renders the published expvar metrics in the Prometheus text exposition format.
+func (app *application) prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+
+	writeMetric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+
+	writeMetric("greenlight_requests_received_total", "counter", "Total number of requests received.",
+		expvar.Get("total_requests_received").(*expvar.Int).Value())
+	writeMetric("greenlight_responses_sent_total", "counter", "Total number of responses sent.",
+		expvar.Get("total_responses_sent").(*expvar.Int).Value())
+	writeMetric("greenlight_in_flight_requests", "gauge", "Number of requests currently being handled.",
+		expvar.Get("in_flight_requests").(*expvar.Int).Value())
+	writeMetric("greenlight_goroutines", "gauge", "Number of goroutines.", runtime.NumGoroutine())
+
+	b.WriteString("# HELP greenlight_responses_sent_by_status_total Total number of responses sent by status code.\n")
+	b.WriteString("# TYPE greenlight_responses_sent_by_status_total counter\n")
+	expvar.Get("total_responses_sent_by_status").(*expvar.Map).Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(&b, "greenlight_responses_sent_by_status_total{status=%q} %s\n", kv.Key, kv.Value.String())
+	})
+
+	b.WriteString("# HELP greenlight_request_duration_seconds Request duration by route.\n")
+	b.WriteString("# TYPE greenlight_request_duration_seconds histogram\n")
+
+	snapshot := expvar.Get("request_duration_seconds").(*routeLatency).Snapshot()
+
+	routes := make([]string, 0, len(snapshot))
+	for route := range snapshot {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	for _, route := range routes {
+		s := snapshot[route]
+		method, pattern, _ := strings.Cut(route, " ")
+		labels := fmt.Sprintf("method=%q,route=%q", method, pattern)
+
+		for i, upperBound := range latencyBuckets {
+			fmt.Fprintf(&b, "greenlight_request_duration_seconds_bucket{%s,le=%q} %d\n",
+				labels, strconv.FormatFloat(upperBound, 'f', -1, 64), s.Buckets[i])
+		}
+		fmt.Fprintf(&b, "greenlight_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, s.Count)
+		fmt.Fprintf(&b, "greenlight_request_duration_seconds_sum{%s} %g\n", labels, s.Sum)
+		fmt.Fprintf(&b, "greenlight_request_duration_seconds_count{%s} %d\n", labels, s.Count)
+	}
+
+	if db, ok := expvar.Get("database").(expvar.Func); ok {
+		stats := db.Value().(map[string]interface{})
+
+		writeMetric("greenlight_db_acquired_conns", "gauge", "Connections currently acquired from the pool.", stats["acquired_conns"])
+		writeMetric("greenlight_db_idle_conns", "gauge", "Idle connections in the pool.", stats["idle_conns"])
+		writeMetric("greenlight_db_total_conns", "gauge", "Total connections in the pool.", stats["total_conns"])
+		writeMetric("greenlight_db_max_conns", "gauge", "Maximum size of the pool.", stats["max_conns"])
+		writeMetric("greenlight_db_acquire_total", "counter", "Total successful connection acquires.", stats["acquire_count"])
+		writeMetric("greenlight_db_empty_acquire_total", "counter", "Acquires that had to wait for a connection.", stats["empty_acquire_count"])
+		writeMetric("greenlight_db_canceled_acquire_total", "counter", "Acquires canceled by their context.", stats["canceled_acquire_count"])
+		writeMetric("greenlight_db_acquire_wait_seconds_total", "counter", "Total time spent acquiring connections.", stats["acquire_duration_seconds"])
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(b.String()))
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// requireMetricsAuth() protects the metrics endpoints with HTTP basic authentication. Credentials
+// are compared in constant time. The server refuses to start with metrics enabled but no
+// credentials configured, and as a safeguard every request is rejected if that check is bypassed.
+func (app *application) requireMetricsAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if ok && app.config.metrics.username != "" && app.config.metrics.password != "" {
+			usernameHash := sha256.Sum256([]byte(username))
+			passwordHash := sha256.Sum256([]byte(password))
+			expectedUsernameHash := sha256.Sum256([]byte(app.config.metrics.username))
+			expectedPasswordHash := sha256.Sum256([]byte(app.config.metrics.password))
+
+			usernameMatch := subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1
+			passwordMatch := subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1
+
+			if usernameMatch && passwordMatch {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+		app.errorResponse(w, r, http.StatusUnauthorized, "you must be authenticated to access this resource")
+	})
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emmasela/greenlight/internal/data"
+	"github.com/emmasela/greenlight/internal/validator"
+	"golang.org/x/time/rate"
+)
+
+// requestIDRX matches the request IDs we are willing to accept from clients
+var requestIDRX = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,128}$`)
+
+// requestID() reads the X-Request-ID header from the request, generating a new random ID if it
+// is missing or malformed. The ID is stored in the request context and echoed in the response.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+
+		if !requestIDRX.MatchString(requestID) {
+			b := make([]byte, 16)
+
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			requestID = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+
+		r = app.contextSetRequestID(r, requestID)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// responseRecorder wraps an http.ResponseWriter to capture the status code and the number of
+// bytes written in the response body.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode    int
+	bytesWritten  int
+	headerWritten bool
+}
+
+func (rr *responseRecorder) WriteHeader(statusCode int) {
+	if !rr.headerWritten {
+		rr.statusCode = statusCode
+		rr.headerWritten = true
+	}
+
+	rr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.headerWritten = true
+
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytesWritten += n
+
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to reach the underlying ResponseWriter
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// logAccess() writes an access log entry for every request once it has been handled, recording
+// the response status, body size, duration and the pattern of the route the request matched.
+func (app *application) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rr := &responseRecorder{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+
+		r = app.contextWithRoute(r)
+
+		// The request is logged from a deferred function so that requests which are aborted with
+		// a panic (such as a streamed export which fails part way through) are logged too
+		completed := false
+
+		defer func() {
+			message := "request completed"
+			if !completed {
+				message = "request aborted"
+			}
+
+			app.logger.PrintInfo(message, map[string]string{
+				"request_id":     app.contextGetRequestID(r),
+				"request_method": r.Method,
+				"route":          app.contextGetRoute(r),
+				"remote_addr":    r.RemoteAddr,
+				"status":         strconv.Itoa(rr.statusCode),
+				"bytes":          strconv.Itoa(rr.bytesWritten),
+				"duration":       time.Since(start).String(),
+			})
+		}()
+
+		next.ServeHTTP(rr, r)
+		completed = true
+	})
+}
+
+// recordRoute() wraps the handler registered for a route so that it records the route's pattern
+// (e.g. "/api/v1/movies/:id") in the request context, keeping logs and metrics from being
+// fragmented by the values of path parameters.
+func (app *application) recordRoute(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app.contextSetRoute(r, pattern)
+		next(w, r)
+	}
+}
+
+// recoverPanic() recovers from any panic raised further down the handler chain, logs it along with
+// the stack trace and sends the client a 500 Internal Server Error response instead of dropping
+// the connection.
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				// http.ErrAbortHandler is how a handler which has already started its response
+				// (e.g. a streamed export) aborts it, so let the server drop the connection
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				// Make Go's HTTP server close the connection once the response has been sent
+				w.Header().Set("Connection", "close")
+
+				// The stack trace of the panic is recorded by the logger as part of the error entry
+				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimit() applies a token-bucket rate limit to each client IP address. Every response carries
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers describing the client's quota,
+// and clients that exceed it receive a 429 Too Many Requests response.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	type client struct {
+		limiter  *rate.Limiter
+		lastSeen time.Time
+	}
+
+	var (
+		mu      sync.Mutex
+		clients = make(map[string]*client)
+	)
+
+	// Launch a janitor goroutine which removes clients that haven't been seen recently. Like the
+	// other background goroutines it is tracked by the WaitGroup and stops on shutdown.
+	app.background(func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.done:
+				return
+			case <-ticker.C:
+			}
+
+			mu.Lock()
+
+			for ip, client := range clients {
+				if time.Since(client.lastSeen) > 3*time.Minute {
+					delete(clients, ip)
+				}
+			}
+
+			mu.Unlock()
+		}
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := app.clientIP(r)
+
+		mu.Lock()
+
+		if _, found := clients[ip]; !found {
+			clients[ip] = &client{
+				limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
+			}
+		}
+
+		c := clients[ip]
+		c.lastSeen = time.Now()
+
+		allowed := c.limiter.Allow()
+		tokens := c.limiter.Tokens()
+
+		mu.Unlock()
+
+		// Report the seconds until the bucket is full again as the reset time
+		reset := 0
+		if missing := float64(app.config.limiter.burst) - tokens; missing > 0 && app.config.limiter.rps > 0 {
+			reset = int(math.Ceil(missing / app.config.limiter.rps))
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(app.config.limiter.burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(math.Max(0, math.Floor(tokens)))))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(reset))
+
+		if !allowed {
+			if app.config.limiter.rps > 0 {
+				retryAfter := int(math.Ceil((1 - tokens) / app.config.limiter.rps))
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			}
+
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP() returns the IP address of the client making the request. The X-Forwarded-For and
+// X-Real-IP headers are only honoured when the request comes directly from a trusted proxy, in
+// which case the right-most untrusted address in X-Forwarded-For is used.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !app.isTrustedProxy(host) {
+		return host
+	}
+
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		addrs := strings.Split(forwardedFor, ",")
+
+		for i := len(addrs) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(addrs[i])
+			if addr != "" && !app.isTrustedProxy(addr) {
+				return addr
+			}
+		}
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+
+	return host
+}
+
+// isTrustedProxy() reports whether the IP address is within one of the configured trusted proxy ranges.
+func (app *application) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+
+	for _, prefix := range app.config.limiter.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// enableCORS() adds the CORS headers for requests coming from one of the trusted origins. Only exact
+// origin matches are reflected back, and preflight requests are answered directly with the allowed
+// methods and headers.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response varies depending on the Origin and preflight request headers, so tell any
+		// caches about it even when no CORS headers end up being set
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" {
+			for _, trustedOrigin := range app.config.cors.trustedOrigins {
+				if origin != trustedOrigin {
+					continue
+				}
+
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Expose-Headers", "Location, X-Request-ID, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After")
+
+				// A preflight request is an OPTIONS request carrying Access-Control-Request-Method
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, X-Expected-Version, X-Request-ID")
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(app.config.cors.maxAge.Seconds())))
+
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+
+				break
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate() resolves the bearer token in the Authorization header to a user and stores it in
+// the request context. Requests without an Authorization header are given the anonymous user.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response varies depending on the Authorization header, so tell any caches about it
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			r = app.contextSetActor(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Expect the header to be in the format "Bearer <token>"
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		v := validator.New()
+
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+		r = app.contextSetActor(r, user)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAuthenticatedUser() rejects requests made by the anonymous user.
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireActivatedUser() rejects requests made by anonymous users or users whose account has not
+// been activated.
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+// requirePermission() rejects requests made by users who have not been granted the given
+// permission code. The user must also be authenticated and activated.
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireActivatedUser(fn)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emmasela/greenlight/internal/jsonlog"
+)
+
+// The expvar variables are process-wide and can only be published once
+var publishMetricsOnce sync.Once
+
+// newTestApplication() returns an application with metrics credentials and no database, logging to
+// the given writer.
+func newTestApplication(t *testing.T, out io.Writer) *application {
+	t.Helper()
+
+	publishMetricsOnce.Do(func() { publishMetrics(nil) })
+
+	app := &application{
+		logger: jsonlog.New(out, jsonlog.LevelInfo),
+		done:   make(chan struct{}),
+	}
+
+	app.config.metrics.username = "metrics"
+	app.config.metrics.password = "pa55word"
+
+	t.Cleanup(func() {
+		close(app.done)
+		app.wg.Wait()
+	})
+
+	return app
+}
+
+// newPanicHandler() builds the application's handler with the given handler registered in place of
+// the API route and metrics endpoint being tested, so that both the main middleware chain and the
+// metrics mux are exercised.
+func newPanicHandler(app *application, fn http.HandlerFunc) http.Handler {
+	// The real metrics endpoints are left out of the router so the test handler can take their place
+	app.config.metrics.enabled = false
+	router := app.router()
+
+	for _, pattern := range []string{"/api/v1/panic", "/metrics"} {
+		router.Handler(http.MethodGet, pattern, app.recordRoute(pattern, fn))
+	}
+
+	app.config.metrics.enabled = true
+	return app.middleware(router)
+}
+
+func TestRecoverPanic(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "API route", path: "/api/v1/panic"},
+		{name: "metrics endpoint", path: "/metrics"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+
+			app := newTestApplication(t, &logs)
+			handler := newPanicHandler(app, func(w http.ResponseWriter, r *http.Request) {
+				panic("something went wrong")
+			})
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rr.Code != http.StatusInternalServerError {
+				t.Errorf("got status %d; want %d", rr.Code, http.StatusInternalServerError)
+			}
+
+			if got := rr.Header().Get("Connection"); got != "close" {
+				t.Errorf("got Connection header %q; want %q", got, "close")
+			}
+
+			if got := rr.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("got Content-Type header %q; want %q", got, "application/json")
+			}
+
+			var body struct {
+				Error string `json:"error"`
+			}
+
+			err := json.NewDecoder(rr.Body).Decode(&body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := "the server encountered a problem and could not process your request"; body.Error != want {
+				t.Errorf("got error %q; want %q", body.Error, want)
+			}
+
+			if !strings.Contains(logs.String(), "something went wrong") {
+				t.Errorf("panic was not logged: %s", logs.String())
+			}
+		})
+	}
+}
+
+func TestRecoverPanicAbortHandler(t *testing.T) {
+	var logs bytes.Buffer
+
+	app := newTestApplication(t, &logs)
+	handler := newPanicHandler(app, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		panic(http.ErrAbortHandler)
+	})
+
+	// The panic must reach the server, which aborts the response rather than completing it
+	func() {
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Errorf("got panic %v; want http.ErrAbortHandler", err)
+			}
+		}()
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/panic", nil))
+	}()
+
+	if !strings.Contains(logs.String(), `"message":"request aborted"`) {
+		t.Errorf("aborted request was not logged: %s", logs.String())
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/emmasela/greenlight/internal/jsonlog"
+	"github.com/emmasela/greenlight/internal/migrate"
+)
+
+const migrateUsage = "usage: api [flags] migrate up|down [N]|goto N|version|force N"
+
+// runMigrateCommand() handles the "migrate" subcommand, whose arguments are given in args.
+func runMigrateCommand(migrator *migrate.Migrator, logger *jsonlog.Logger, args []string) error {
+	ctx := context.Background()
+
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	var err error
+
+	switch args[0] {
+	case "up":
+		err = migrator.Up(ctx)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errors.New(migrateUsage)
+			}
+		}
+		err = migrator.Down(ctx, steps)
+
+	case "goto", "force":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+
+		version, parseErr := strconv.ParseInt(args[1], 10, 64)
+		if parseErr != nil || version < 0 {
+			return errors.New(migrateUsage)
+		}
+
+		if args[0] == "goto" {
+			err = migrator.Goto(ctx, version)
+		} else {
+			err = migrator.Force(ctx, version)
+		}
+
+	case "version":
+
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+
+	version, dirty, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	logger.PrintInfo("database schema version", map[string]string{
+		"version": strconv.FormatInt(version, 10),
+		"latest":  strconv.FormatInt(migrator.Latest(), 10),
+		"dirty":   strconv.FormatBool(dirty),
+	})
+
+	return nil
+}
+
+// checkSchemaVersion() returns an error if the database schema is dirty or hasn't been migrated
+// up to the latest version, as the API can't be expected to work against an older schema.
+func checkSchemaVersion(migrator *migrate.Migrator) error {
+	version, dirty, err := migrator.Version(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return migrate.ErrDirty
+	}
+
+	if version < migrator.Latest() {
+		return fmt.Errorf("database schema is at version %d but the latest is %d, run \"migrate up\" first", version, migrator.Latest())
+	}
+
+	return nil
+}
@@ -1,15 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"strconv"
 
 	"github.com/emmasela/greenlight/internal/data"
+	"github.com/emmasela/greenlight/internal/patch"
 	"github.com/emmasela/greenlight/internal/validator"
 )
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
+	// Read the format the runtime should be rendered in before making any changes
+	runtimeFormat, err := app.readRuntimeFormat(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
 	var input struct {
 		Title   string       `json:"title"`
 		Year    int32        `json:"year"`
@@ -18,7 +30,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Encode JSON input data into put struct
-	err := app.readJSON(w, r, &input)
+	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -37,17 +49,19 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// JSON input data gets validated and an error is returned in the response if a validation check fails
 	if data.ValidateMovie(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Calling Insert() method on the movies model passing in a pointer to the validated movie struct
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(r.Context(), movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	movie.RuntimeFormat = runtimeFormat
+
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("api/v1/movies/%d", movie.ID))
 
@@ -64,8 +78,14 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	runtimeFormat, err := app.readRuntimeFormat(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
 	// Calling Get() method to fetch data for a specific movie
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -75,6 +95,9 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+
+	movie.RuntimeFormat = runtimeFormat
+
 	// Encode the struct to JSON and send it as HTTP response
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
@@ -91,9 +114,15 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	runtimeFormat, err := app.readRuntimeFormat(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
 	// Fetch the existing movie from the database by its ID.
 	// If the movie is not found, respond with a 404. For other errors, respond with a 500 server error.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -104,6 +133,19 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// If the client supplied the version it expects to be updating, reject the request straight
+	// away when it no longer matches the stored record.
+	expectedVersion, ok, err := app.readExpectedVersion(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if ok && expectedVersion != movie.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
 	// Define a struct to hold the updated movie details from the incoming JSON request.
 	var input struct {
 		Title   string       `json:"title"`
@@ -131,25 +173,236 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	v := validator.New()
 
 	if data.ValidateMovie(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Update the movie record in the database.
-	// If there is a problem with the update, respond with a 500 server error.
-	err = app.models.Movies.Update(movie)
+	// If the record was modified since we read it, respond with a 409 Conflict. For any other
+	// problem, respond with a 500 server error.
+	err = app.models.Movies.Update(r.Context(), movie)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
 	// Send a 200 OK response along with the updated movie details in JSON format.
+	movie.RuntimeFormat = runtimeFormat
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// patchMovieHandler handles partial updates to a specific movie. The request body is interpreted
+// according to its Content-Type: a plain JSON object only changes the keys it contains, while
+// application/merge-patch+json (RFC 7396) and application/json-patch+json (RFC 6902) documents are
+// applied to the movie's JSON representation.
+func (app *application) patchMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	runtimeFormat, err := app.readRuntimeFormat(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	expectedVersion, ok, err := app.readExpectedVersion(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if ok && expectedVersion != movie.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	mediaType := ""
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			app.unsupportedMediaTypeResponse(w, r)
+			return
+		}
+	}
+
+	switch mediaType {
+	case "", "application/json":
+		// Use pointer fields so that we can tell which keys were present in the request body.
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		}
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+		if input.Genres != nil {
+			movie.Genres = input.Genres
+		}
+
+	case "application/merge-patch+json":
+		var mergePatch interface{}
+
+		err = app.readJSON(w, r, &mergePatch)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		err = app.patchMovieDocument(movie, func(doc interface{}) (interface{}, error) {
+			return patch.Merge(doc, mergePatch), nil
+		})
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+	case "application/json-patch+json":
+		var ops []patch.Operation
+
+		err = app.readJSON(w, r, &ops)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		err = app.patchMovieDocument(movie, func(doc interface{}) (interface{}, error) {
+			return patch.Apply(doc, ops)
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, patch.ErrTestFailed):
+				app.editConflictResponse(w, r)
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.Movies.Update(r.Context(), movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie.RuntimeFormat = runtimeFormat
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// patchMovieDocument() converts the movie to its generic JSON representation, passes it through the
+// apply function and copies the editable fields of the result back into the movie. The id and
+// version fields are read-only and any attempt to change them is rejected.
+func (app *application) patchMovieDocument(movie *data.Movie, apply func(doc interface{}) (interface{}, error)) error {
+	js, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+	err = json.Unmarshal(js, &doc)
+	if err != nil {
+		return err
+	}
+
+	doc, err = apply(doc)
+	if err != nil {
+		return err
+	}
+
+	js, err = json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	var patched struct {
+		ID      int64        `json:"id"`
+		Title   string       `json:"title"`
+		Year    int32        `json:"year"`
+		Runtime data.Runtime `json:"runtime"`
+		Genres  []string     `json:"genres"`
+		Version int32        `json:"version"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&patched)
+	if err != nil {
+		return fmt.Errorf("patched movie is invalid: %w", err)
+	}
+
+	if patched.ID != movie.ID || patched.Version != movie.Version {
+		return errors.New("the id and version fields cannot be modified")
+	}
+
+	movie.Title = patched.Title
+	movie.Year = patched.Year
+	movie.Runtime = patched.Runtime
+	movie.Genres = patched.Genres
+
+	return nil
+}
+
+// deleteMovieHandler soft deletes a specific movie so it can later be restored. Admins can pass
+// ?purge=true to remove the movie permanently instead.
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -157,7 +410,36 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	purge := false
+	if value := r.URL.Query().Get("purge"); value != "" {
+		purge, err = strconv.ParseBool(value)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("purge must be a boolean value"))
+			return
+		}
+	}
+
+	message := "movie deleted"
+
+	if purge {
+		// Permanently deleting a movie can't be undone, so it is reserved for admins
+		permissions, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include("movies:admin") {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		err = app.models.Movies.Purge(r.Context(), id)
+		message = "movie purged"
+	} else {
+		err = app.models.Movies.Delete(r.Context(), id)
+	}
+
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -168,7 +450,102 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreMovieHandler undoes the soft delete of a specific movie.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	runtimeFormat, err := app.readRuntimeFormat(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movie, err := app.models.Movies.Restore(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie.RuntimeFormat = runtimeFormat
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listMoviesHandler handles requests for a filtered, sorted and paginated list of movies.
+func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// Define a struct to hold the expected values from the query string.
+	var input struct {
+		Title  string
+		Genres []string
+		Search string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	// Extract the query string values, falling back to sensible defaults.
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Search = app.readString(qs, "q", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// Search results are ordered by relevance unless the client asks otherwise.
+	defaultSort := "id"
+	if input.Search != "" {
+		defaultSort = "-relevance"
+	}
+
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+	input.Filters.SortSafelist = []string{
+		"id", "title", "year", "runtime", "relevance",
+		"-id", "-title", "-year", "-runtime", "-relevance",
+	}
+
+	// Validate the filters, responding with a 422 Unprocessable Entity if any check fails.
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	runtimeFormat, err := app.readRuntimeFormat(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.Search, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, movie := range movies {
+		movie.RuntimeFormat = runtimeFormat
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/emmasela/greenlight/internal/validator"
+)
+
+// problemFieldError describes a single validation failure, in the "errors" extension member of a
+// problem details response.
+type problemFieldError struct {
+	Field   string                 `json:"field"`
+	Pointer string                 `json:"pointer"`
+	Code    string                 `json:"code"`
+	Detail  string                 `json:"detail"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+}
+
+// The problem types with their own type URI and title. Every other problem uses "about:blank",
+// which per RFC 7807 means the title is the standard phrase for the HTTP status code.
+const (
+	problemTypeValidation   = "https://greenlight.local/problems/validation-error"
+	problemTypeEditConflict = "https://greenlight.local/problems/edit-conflict"
+	problemTypeRateLimit    = "https://greenlight.local/problems/rate-limit-exceeded"
+)
+
+// wantsProblemJSON() reports whether the error response for the request should use the RFC 7807
+// application/problem+json format. Clients can ask for it explicitly via the Accept header;
+// otherwise the configured default error format applies.
+func (app *application) wantsProblemJSON(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err == nil && mediaType == "application/problem+json" {
+			return true
+		}
+	}
+
+	return app.config.errorFormat == "problem"
+}
+
+// problemResponse() sends an RFC 7807 problem details document. String messages become the
+// "detail" member, while validation errors are reported in the "errors" extension member
+// with a JSON Pointer to each offending field.
+func (app *application) problemResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) error {
+	problem := envelope{
+		"type":     "about:blank",
+		"title":    http.StatusText(status),
+		"status":   status,
+		"instance": r.URL.Path,
+	}
+
+	switch status {
+	case http.StatusConflict:
+		problem["type"] = problemTypeEditConflict
+		problem["title"] = "Edit conflict"
+	case http.StatusTooManyRequests:
+		problem["type"] = problemTypeRateLimit
+		problem["title"] = "Rate limit exceeded"
+	}
+
+	if requestID := app.contextGetRequestID(r); requestID != "" {
+		problem["request_id"] = requestID
+	}
+
+	switch message := message.(type) {
+	case string:
+		problem["detail"] = message
+
+	case *validator.Validator:
+		problem["type"] = problemTypeValidation
+		problem["title"] = "Validation failed"
+		problem["detail"] = "one or more fields failed validation"
+		problem["errors"] = problemFieldErrors(message)
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/problem+json")
+
+	return app.writeJSON(w, status, problem, headers)
+}
+
+// problemFieldErrors() lists the validation errors recorded by the validator, sorted by field so
+// that the response is deterministic.
+func problemFieldErrors(v *validator.Validator) []problemFieldError {
+	fields := make([]string, 0, len(v.FieldErrors))
+	for field := range v.FieldErrors {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+
+	fieldErrors := []problemFieldError{}
+	for _, field := range fields {
+		for _, fieldError := range v.FieldErrors[field] {
+			fieldErrors = append(fieldErrors, problemFieldError{
+				Field:   field,
+				Pointer: validator.Pointer(field),
+				Code:    fieldError.Code,
+				Detail:  fieldError.Message,
+				Params:  fieldError.Params,
+			})
+		}
+	}
+
+	return fieldErrors
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/emmasela/greenlight/internal/data"
+	"github.com/emmasela/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// readVersionParam() reads the :version parameter from the URL
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+
+	return int32(version), nil
+}
+
+// readVersionQuery() reads a required version number from the query string, recording a
+// validation error if it is missing or isn't a positive 32-bit integer.
+func (app *application) readVersionQuery(qs url.Values, key string, v *validator.Validator) int32 {
+	s := qs.Get(key)
+
+	if s == "" {
+		v.AddFieldError(key, validator.CodeRequired, "must be provided", nil)
+		return 0
+	}
+
+	version, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		var numError *strconv.NumError
+		if errors.As(err, &numError) && errors.Is(numError.Err, strconv.ErrRange) {
+			v.AddFieldError(key, validator.CodeOutOfRange, "must be a valid version number",
+				map[string]interface{}{"min": 1, "max": math.MaxInt32})
+			return 0
+		}
+
+		v.AddFieldError(key, validator.CodeInvalidType, "must be an integer value", map[string]interface{}{"type": "integer"})
+		return 0
+	}
+
+	v.CheckCode(version >= 1, key, validator.CodeOutOfRange, "must be a valid version number",
+		map[string]interface{}{"min": 1, "max": math.MaxInt32})
+
+	return int32(version)
+}
+
+// listMovieRevisionsHandler() sends a page of the change history of a specific movie
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var filters data.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-version")
+	filters.SortSafelist = []string{"version", "-version"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	runtimeFormat, err := app.readRuntimeFormat(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	revisions, metadata, err := app.models.Movies.GetRevisions(r.Context(), id, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, revision := range revisions {
+		revision.Movie.RuntimeFormat = runtimeFormat
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showMovieRevisionHandler() sends a specific movie as it was at the given version
+func (app *application) showMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	runtimeFormat, err := app.readRuntimeFormat(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	revision, err := app.models.Movies.GetRevision(r.Context(), id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	revision.Movie.RuntimeFormat = runtimeFormat
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// diffMovieRevisionsHandler() sends the field-level changes to a specific movie between the
+// versions given by the from and to query string parameters.
+func (app *application) diffMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	from := app.readVersionQuery(qs, "from", v)
+	to := app.readVersionQuery(qs, "to", v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	runtimeFormat, err := app.readRuntimeFormat(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var revisions [2]*data.MovieRevision
+
+	for i, version := range []int32{from, to} {
+		revisions[i], err = app.models.Movies.GetRevision(r.Context(), id, version)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	changes := data.DiffMovies(&revisions[0].Movie, &revisions[1].Movie)
+
+	// Render runtimes the same way as everywhere else in the response
+	for i := range changes {
+		if changes[i].Field == "runtime" {
+			changes[i].From = changes[i].From.(data.Runtime).Format(runtimeFormat)
+			changes[i].To = changes[i].To.(data.Runtime).Format(runtimeFormat)
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"diff": envelope{
+		"movie_id": id,
+		"from":     revisions[0].Version,
+		"to":       revisions[1].Version,
+		"changes":  changes,
+	}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revertMovieHandler() restores the fields of a specific movie to those it had at the given
+// version. The revert is saved as a new version rather than discarding the later history.
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	runtimeFormat, err := app.readRuntimeFormat(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	expectedVersion, ok, err := app.readExpectedVersion(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if ok && expectedVersion != movie.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	revision, err := app.models.Movies.GetRevision(r.Context(), id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie.Title = revision.Movie.Title
+	movie.Year = revision.Movie.Year
+	movie.Runtime = revision.Movie.Runtime
+	movie.Genres = revision.Movie.Genres
+
+	// The rules may have tightened since the revision was saved, so it is validated like any
+	// other update
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.Movies.Revert(r.Context(), movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie.RuntimeFormat = runtimeFormat
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,12 +1,40 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-func (app *application) routes() *httprouter.Router {
+func (app *application) routes() http.Handler {
+	return app.middleware(app.bulkRoutes(app.router()))
+}
+
+// bulkRoutes() serves the bulk movie endpoints, passing every other request on to the router.
+// httprouter doesn't allow a static segment such as "import" or "export" in the same position as
+// the :id parameter of the other movie routes, so these are matched first by a ServeMux instead.
+// Requests with another method fall through to the router, which handles them as it would any
+// other :id.
+func (app *application) bulkRoutes(router http.Handler) http.Handler {
+	mux := http.NewServeMux()
+
+	// handle() registers a route, recording its pattern for the access log and metrics
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		mux.Handle(method+" "+pattern, app.recordRoute(pattern, handler))
+	}
+
+	handle(http.MethodPost, "/api/v1/movies/import", app.requirePermission("movies:write", app.importMoviesHandler))
+	handle(http.MethodGet, "/api/v1/movies/export", app.requirePermission("movies:read", app.exportMoviesHandler))
+
+	mux.Handle("/", router)
+
+	return mux
+}
+
+// router() registers every route, including the metrics endpoints if they are enabled, with a new
+// router.
+func (app *application) router() *httprouter.Router {
 	//	Initialize a new httprouter router instance
 	router := httprouter.New()
 
@@ -14,12 +42,55 @@ func (app *application) routes() *httprouter.Router {
 
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
+	// handle() registers a route, recording its pattern for the access log and metrics
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, pattern, app.recordRoute(pattern, handler))
+	}
+
 	//	Register the relevant methods and URL patterns with their respective handlers
-	router.HandlerFunc(http.MethodGet, "/api/v1/healthcheck", app.healthCheckHandler)
-	router.HandlerFunc(http.MethodPost, "/api/v1/movies", app.createMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/api/v1/movies/:id", app.showMovieHandler)
-	router.HandlerFunc(http.MethodPut, "/api/v1/movies/:id", app.updateMovieHandler)
-	router.HandlerFunc(http.MethodDelete, "/api/v1/movies/:id", app.deleteMovieHandler)
+	handle(http.MethodGet, "/api/v1/healthcheck", app.healthCheckHandler)
+	handle(http.MethodGet, "/api/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
+	handle(http.MethodPost, "/api/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
+	handle(http.MethodGet, "/api/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	handle(http.MethodPut, "/api/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	handle(http.MethodPatch, "/api/v1/movies/:id", app.requirePermission("movies:write", app.patchMovieHandler))
+	handle(http.MethodDelete, "/api/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	handle(http.MethodPost, "/api/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+	handle(http.MethodGet, "/api/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	handle(http.MethodGet, "/api/v1/movies/:id/revisions/:version", app.requirePermission("movies:read", app.showMovieRevisionHandler))
+	handle(http.MethodPost, "/api/v1/movies/:id/revisions/:version/revert", app.requirePermission("movies:write", app.revertMovieHandler))
+	handle(http.MethodGet, "/api/v1/movies/:id/diff", app.requirePermission("movies:read", app.diffMovieRevisionsHandler))
+
+	handle(http.MethodPost, "/api/v1/users", app.registerUserHandler)
+	handle(http.MethodPut, "/api/v1/users/activated", app.activateUserHandler)
+
+	handle(http.MethodPost, "/api/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+
+	if app.config.metrics.enabled {
+		handle(http.MethodGet, "/debug/vars", app.requireMetricsAuth(expvar.Handler()).ServeHTTP)
+		handle(http.MethodGet, "/metrics", app.requireMetricsAuth(http.HandlerFunc(app.prometheusHandler)).ServeHTTP)
+	}
 
 	return router
 }
+
+// middleware() wraps the router in the middleware chain shared by every request.
+func (app *application) middleware(router http.Handler) http.Handler {
+	var handler http.Handler = app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
+
+	if app.config.metrics.enabled {
+		// The metrics endpoints use basic auth rather than bearer tokens, so they bypass the token
+		// authentication applied to the API itself. They are still rate limited, with their own
+		// limiter so that scraping doesn't eat into a client's API quota.
+		mux := http.NewServeMux()
+		metricsHandler := app.recoverPanic(app.rateLimit(router))
+
+		mux.Handle("/debug/vars", metricsHandler)
+		mux.Handle("/metrics", metricsHandler)
+		mux.Handle("/", app.metrics(handler))
+
+		handler = mux
+	}
+
+	return app.requestID(app.logAccess(handler))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBulkRoutes(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantRoute  string
+		wantAllow  string
+	}{
+		{
+			name:       "import",
+			method:     http.MethodPost,
+			path:       "/api/v1/movies/import",
+			wantStatus: http.StatusUnauthorized,
+			wantRoute:  "/api/v1/movies/import",
+		},
+		{
+			name:       "export",
+			method:     http.MethodGet,
+			path:       "/api/v1/movies/export",
+			wantStatus: http.StatusUnauthorized,
+			wantRoute:  "/api/v1/movies/export",
+		},
+		{
+			name:       "movie by ID",
+			method:     http.MethodGet,
+			path:       "/api/v1/movies/1",
+			wantStatus: http.StatusUnauthorized,
+			wantRoute:  "/api/v1/movies/:id",
+		},
+		{
+			name:       "unsupported method on a movie",
+			method:     http.MethodPost,
+			path:       "/api/v1/movies/1",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantRoute:  "unmatched",
+			wantAllow:  "DELETE, GET, OPTIONS, PATCH, PUT",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+
+			app := newTestApplication(t, &logs)
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d", rr.Code, tt.wantStatus)
+			}
+
+			if got := rr.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("got Allow header %q; want %q", got, tt.wantAllow)
+			}
+
+			var entry struct {
+				Properties map[string]string `json:"properties"`
+			}
+
+			err := json.Unmarshal(logs.Bytes(), &entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := entry.Properties["route"]; got != tt.wantRoute {
+				t.Errorf("got route %q; want %q", got, tt.wantRoute)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve() starts the HTTP server and blocks until it has been shut down. On SIGINT or SIGTERM the
+// server stops accepting new connections and is given the configured grace period to finish
+// in-flight requests, after which any tracked background goroutines are waited for.
+func (app *application) serve() error {
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(),
+		ErrorLog:     log.New(app.logger, "", 0),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	app.startPurgeJob()
+
+	// Receives any error returned by the graceful Shutdown() call
+	shutdownError := make(chan error)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+		s := <-quit
+
+		app.logger.PrintInfo("shutting down server", map[string]string{
+			"signal": s.String(),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		err := server.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		app.logger.PrintInfo("completing background tasks", map[string]string{
+			"addr": server.Addr,
+		})
+
+		close(app.done)
+
+		app.wg.Wait()
+		shutdownError <- nil
+	}()
+
+	app.logger.PrintInfo("starting server", map[string]string{
+		"addr": server.Addr,
+		"env":  app.config.env,
+	})
+
+	// ListenAndServe returns http.ErrServerClosed straight away once Shutdown() has been called,
+	// so that is the only error we expect here.
+	err := server.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("stopped server", map[string]string{
+		"addr": server.Addr,
+	})
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package data
+
+import (
+	"math"
+	"strings"
+
+	"github.com/emmasela/greenlight/internal/validator"
+)
+
+// Filters holds the pagination and sorting parameters for listing endpoints
+type Filters struct {
+	Page         int
+	PageSize     int
+	Sort         string
+	SortSafelist []string
+}
+
+// Metadata holds the pagination metadata returned alongside listing results
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records,omitempty"`
+}
+
+// ValidateFilters checks that the page, page_size and sort values are within sensible bounds
+func ValidateFilters(v *validator.Validator, f Filters) {
+	pageRange := map[string]interface{}{"min": 1, "max": 10_000_000}
+	v.CheckCode(f.Page > 0, "page", validator.CodeOutOfRange, "must be greater than zero", pageRange)
+	v.CheckCode(f.Page <= 10_000_000, "page", validator.CodeOutOfRange, "must be a maximum of 10 million", pageRange)
+
+	pageSizeRange := map[string]interface{}{"min": 1, "max": 100}
+	v.CheckCode(f.PageSize > 0, "page_size", validator.CodeOutOfRange, "must be greater than zero", pageSizeRange)
+	v.CheckCode(f.PageSize <= 100, "page_size", validator.CodeOutOfRange, "must be a maximum of 100", pageSizeRange)
+
+	v.CheckCode(validator.In(f.Sort, f.SortSafelist...), "sort", validator.CodeInvalid, "invalid sort value",
+		map[string]interface{}{"allowed": f.SortSafelist})
+}
+
+// sortColumn returns the column name to sort by. It panics if the sort value is not in the
+// safelist, which acts as a failsafe against SQL injection.
+func (f Filters) sortColumn() string {
+	for _, safeValue := range f.SortSafelist {
+		if f.Sort == safeValue {
+			return strings.TrimPrefix(f.Sort, "-")
+		}
+	}
+
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+// sortDirection returns the sort direction ("ASC" or "DESC") based on the prefix of the sort value
+func (f Filters) sortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+
+	return "ASC"
+}
+
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// calculateMetadata computes the pagination metadata from the total number of records,
+// the current page and the page size. An empty Metadata is returned if there are no records.
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
@@ -0,0 +1,118 @@
+package data
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// MovieImport bulk inserts movies in batches. In atomic mode every batch is written in a single
+// transaction which is only committed by Commit; otherwise each batch is committed as soon as it
+// has been inserted. Either way Rollback must be called (typically deferred) to release the
+// transaction if the import is abandoned.
+type MovieImport struct {
+	model  MovieModel
+	atomic bool
+	tx     pgx.Tx
+}
+
+// NewImport starts a bulk import of movies
+func (m MovieModel) NewImport(ctx context.Context, atomic bool) (*MovieImport, error) {
+	imp := &MovieImport{model: m, atomic: atomic}
+
+	if atomic {
+		tx, err := m.DB.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		imp.tx = tx
+	}
+
+	return imp, nil
+}
+
+// Insert writes a batch of movies with COPY, setting the ID and version of each movie and
+// recording their first revisions. The movies are expected to have been validated already.
+func (imp *MovieImport) Insert(ctx context.Context, movies []*Movie) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, imp.model.QueryTimeout)
+	defer cancel()
+
+	if imp.atomic {
+		return insertMovies(ctx, imp.tx, movies)
+	}
+
+	return pgx.BeginFunc(ctx, imp.model.DB, func(tx pgx.Tx) error {
+		return insertMovies(ctx, tx, movies)
+	})
+}
+
+// Commit commits the movies inserted by an atomic import. It is a no-op otherwise.
+func (imp *MovieImport) Commit(ctx context.Context) error {
+	if imp.tx == nil {
+		return nil
+	}
+
+	err := imp.tx.Commit(ctx)
+	imp.tx = nil
+	return err
+}
+
+// Rollback discards the movies inserted by an atomic import which hasn't been committed. It is a
+// no-op otherwise, so it is safe to defer.
+func (imp *MovieImport) Rollback(ctx context.Context) error {
+	if imp.tx == nil {
+		return nil
+	}
+
+	err := imp.tx.Rollback(ctx)
+	imp.tx = nil
+
+	if errors.Is(err, pgx.ErrTxClosed) {
+		return nil
+	}
+	return err
+}
+
+// insertMovies copies the movies into the movies table within tx. COPY can't return the generated
+// IDs, so they are reserved from the sequence up front.
+func insertMovies(ctx context.Context, tx pgx.Tx, movies []*Movie) error {
+	query := `
+		SELECT nextval(pg_get_serial_sequence('movies', 'id'))
+		FROM generate_series(1, $1)
+	`
+
+	rows, err := tx.Query(ctx, query, len(movies))
+	if err != nil {
+		return err
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return err
+	}
+
+	for i, movie := range movies {
+		movie.ID = ids[i]
+		movie.Version = 1
+	}
+
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"movies"},
+		[]string{"id", "title", "year", "runtime", "genres"},
+		pgx.CopyFromSlice(len(movies), func(i int) ([]interface{}, error) {
+			movie := movies[i]
+			return []interface{}{movie.ID, movie.Title, movie.Year, int32(movie.Runtime), movie.Genres}, nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	return recordRevision(ctx, tx, RevisionInsert, ids...)
+}
@@ -2,23 +2,32 @@ package data
 
 import (
 	"errors"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // ErrRecordNotFound A custom error which is returned when a resource could not be found
+// ErrEditConflict A custom error which is returned when a record was modified by another request
 var (
 	ErrRecordNotFound = errors.New("record not found")
+	ErrEditConflict   = errors.New("edit conflict")
 )
 
-// Models struct which wraps the MovieModel
+// Models struct which wraps the MovieModel, PermissionModel, TokenModel and UserModel
 type Models struct {
-	Movies MovieModel
+	Movies      MovieModel
+	Permissions PermissionModel
+	Tokens      TokenModel
+	Users       UserModel
 }
 
-// NewModels creates and returns a Model instance containing initialized MovieModel
-func NewModels(db *pgxpool.Pool) Models {
+// NewModels creates and returns a Model instance containing the initialized models
+func NewModels(db *pgxpool.Pool, queryTimeout time.Duration) Models {
 	return Models{
-		Movies: MovieModel{db},
+		Movies:      MovieModel{DB: db, QueryTimeout: queryTimeout},
+		Permissions: PermissionModel{db},
+		Tokens:      TokenModel{db},
+		Users:       UserModel{db},
 	}
 }
@@ -2,7 +2,10 @@ package data
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"reflect"
 	"time"
 
 	"github.com/emmasela/greenlight/internal/validator"
@@ -10,39 +13,75 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Movie is a movie in the catalogue. The validate tags hold the rules checked by ValidateMovie.
 type Movie struct {
 	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,omitempty"`
-	Genres    []string  `json:"genres,omitempty"`
+	CreatedAt time.Time `json:"-" validate:"-"`
+	Title     string    `json:"title" validate:"required,max=500"`
+	Year      int32     `json:"year,omitempty" validate:"required,min=1888,notfuture"`
+	Runtime   Runtime   `json:"runtime,omitempty" validate:"required,min=1"`
+	Genres    []string  `json:"genres,omitempty" validate:"required,min=1,max=5,unique,dive,required"`
 	Version   int32     `json:"version"`
+	// Relevance and Highlight are only populated when listing movies with a full-text search query
+	Relevance float32 `json:"relevance,omitempty"`
+	Highlight string  `json:"highlight,omitempty"`
+	// RuntimeFormat selects how the runtime is rendered by MarshalJSON
+	RuntimeFormat RuntimeFormat `json:"-"`
 }
 
+// MarshalJSON encodes the movie with its runtime rendered in the movie's RuntimeFormat
+func (m Movie) MarshalJSON() ([]byte, error) {
+	// The alias type has the same fields but none of the methods, which avoids infinite recursion
+	type movieAlias Movie
+
+	aux := struct {
+		movieAlias
+		Runtime interface{} `json:"runtime,omitempty"`
+	}{
+		movieAlias: movieAlias(m),
+	}
+
+	if m.Runtime != 0 {
+		aux.Runtime = m.Runtime.Format(m.RuntimeFormat)
+	}
+
+	return json.Marshal(aux)
+}
+
+// MovieModel wraps the connection pool. Every query is bounded by QueryTimeout on top of any
+// deadline already carried by the caller's context.
 type MovieModel struct {
-	DB *pgxpool.Pool
+	DB           *pgxpool.Pool
+	QueryTimeout time.Duration
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
-	v.Check(movie.Title != "", "title", "must be provided")
-	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
+func init() {
+	// A movie's year may be the current year, but not later
+	validator.RegisterRule("notfuture", validator.CodeOutOfRange, "must not be in the future",
+		func(value reflect.Value, _ string) bool {
+			return value.Int() <= int64(time.Now().Year())
+		})
+}
 
-	v.Check(movie.Year != 0, "year", "must be provided")
-	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
+// ValidateMovie checks the movie against the rules in its validate tags, and also reports each
+// genre which repeats an earlier one against its position in the list.
+func ValidateMovie(v *validator.Validator, movie *Movie) {
+	v.Struct(movie)
 
-	v.Check(movie.Runtime != 0, "runtime", "must be provided")
-	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
+	seen := make(map[string]bool, len(movie.Genres))
 
-	v.Check(movie.Genres != nil, "genres", "must be provided")
-	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	for i, genre := range movie.Genres {
+		if seen[genre] {
+			v.AddFieldError(validator.Index("genres", i), validator.CodeDuplicate,
+				"must not be a duplicate of an earlier genre", map[string]interface{}{"value": genre})
+		}
 
-	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+		seen[genre] = true
+	}
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
+// Insert creates the movie and records its first revision in the same transaction.
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
 	query := `
 		INSERT INTO movies (title, year, runtime, genres)
 		VALUES ($1, $2, $3, $4)
@@ -51,22 +90,35 @@ func (m MovieModel) Insert(movie *Movie) error {
 
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, movie.Genres}
 
-	return m.DB.QueryRow(context.Background(), query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, m.DB, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			return err
+		}
+
+		return recordRevision(ctx, tx, RevisionInsert, movie.ID)
+	})
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
 	query := `
 		SELECT id, created_at, title, year, runtime, genres, version
-		FROM Movies
-		WHERE id = $1
+		FROM movies
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 	var movie Movie
 
-	err := m.DB.QueryRow(context.Background(), query, id).Scan(
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -88,11 +140,174 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// GetAll returns a page of movies matching the optional title, genres and full-text search
+// filters, along with the pagination metadata for the full result set. When a search query is
+// given each movie carries its relevance rank and a highlighted snippet of its title. The snippet
+// is HTML: the title is escaped so that the <mark> tags are the only markup in it.
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, search string, filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version,
+			CASE WHEN $3 = '' THEN 0
+				ELSE ts_rank(search, websearch_to_tsquery('english', $3))
+			END AS relevance,
+			CASE WHEN $3 = '' THEN ''
+				ELSE ts_headline('english',
+					replace(replace(replace(replace(title, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'),
+					websearch_to_tsquery('english', $3),
+					'StartSel=<mark>, StopSel=</mark>, HighlightAll=true')
+			END AS highlight
+		FROM movies
+		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (genres @> $2 OR $2 = '{}')
+		AND (search @@ websearch_to_tsquery('english', $3) OR $3 = '')
+		AND deleted_at IS NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $4 OFFSET $5
+	`, filters.sortColumn(), filters.sortDirection())
+
+	if genres == nil {
+		genres = []string{}
+	}
+
+	args := []interface{}{title, genres, search, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			&movie.Genres,
+			&movie.Version,
+			&movie.Relevance,
+			&movie.Highlight,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Export calls fn with every movie matching the same filters as GetAll, in the order given by
+// filters (which need not hold a page). Rather than loading every movie at once, the movies are
+// read from a server-side cursor batchSize rows at a time, within a read-only transaction so that
+// the export is a consistent snapshot. Export stops at the first error returned by fn.
+func (m MovieModel) Export(ctx context.Context, title string, genres []string, search string, filters Filters, batchSize int, fn func(*Movie) error) error {
+	query := fmt.Sprintf(`
+		DECLARE movies_export NO SCROLL CURSOR FOR
+		SELECT id, created_at, title, year, runtime, genres, version,
+			CASE WHEN $3 = '' THEN 0
+				ELSE ts_rank(search, websearch_to_tsquery('english', $3))
+			END AS relevance
+		FROM movies
+		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (genres @> $2 OR $2 = '{}')
+		AND (search @@ websearch_to_tsquery('english', $3) OR $3 = '')
+		AND deleted_at IS NULL
+		ORDER BY %s %s, id ASC
+	`, filters.sortColumn(), filters.sortDirection())
+
+	if genres == nil {
+		genres = []string{}
+	}
+
+	tx, err := m.DB.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	queryCtx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	_, err = tx.Exec(queryCtx, query, title, genres, search)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH %d FROM movies_export", batchSize)
+
+	for {
+		// Read the whole batch before handing it to fn, so that a slow fn (e.g. a slow client)
+		// doesn't count against the query timeout
+		queryCtx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+
+		rows, err := tx.Query(queryCtx, fetch)
+		if err != nil {
+			cancel()
+			return err
+		}
+
+		movies, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Movie, error) {
+			var movie Movie
+
+			err := row.Scan(
+				&movie.ID,
+				&movie.CreatedAt,
+				&movie.Title,
+				&movie.Year,
+				&movie.Runtime,
+				&movie.Genres,
+				&movie.Version,
+				&movie.Relevance,
+			)
+			return &movie, err
+		})
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		if len(movies) == 0 {
+			return nil
+		}
+
+		for _, movie := range movies {
+			if err := fn(movie); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Update saves the movie, provided its version still matches the one stored in the database.
+// If the record was changed (or deleted) in the meantime, ErrEditConflict is returned.
+// Soft deleted movies can't be updated.
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
+	return m.update(ctx, movie, RevisionUpdate)
+}
+
+// update saves the movie and records the change as a revision with the given operation.
+func (m MovieModel) update(ctx context.Context, movie *Movie, operation string) error {
 	query := `
-		UPDATE Movies
+		UPDATE movies
 		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		where id = $5
+		WHERE id = $5 AND version = $6 AND deleted_at IS NULL
 		RETURNING version
 	`
 
@@ -102,30 +317,186 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Runtime,
 		movie.Genres,
 		movie.ID,
+		movie.Version,
 	}
 
-	return m.DB.QueryRow(context.Background(), query, args...).Scan(&movie.Version)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, m.DB, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, query, args...).Scan(&movie.Version)
+		if err != nil {
+			return err
+		}
+
+		return recordRevision(ctx, tx, operation, movie.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+// Delete soft deletes the movie by setting its deleted_at timestamp. The movie is hidden from Get
+// and GetAll until it is restored, and is permanently removed by Purge or PurgeDeletedBefore.
+// Like any other change, the deletion bumps the version and is recorded as a revision.
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-		DELETE FROM movies
-		WHERE id = $1
+		UPDATE movies
+		SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 
-	result, err := m.DB.Exec(context.Background(), query, id)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, m.DB, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, query, id)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected := result.RowsAffected()
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+
+		return recordRevision(ctx, tx, RevisionDelete, id)
+	})
+}
+
+// Restore undoes a soft delete and returns the restored movie, recording the change as a new
+// revision. ErrRecordNotFound is returned if there is no soft deleted movie with the given id.
+func (m MovieModel) Restore(ctx context.Context, id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE movies
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING id, created_at, title, year, runtime, genres, version
+	`
+
+	var movie Movie
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, m.DB, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, query, id).Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			&movie.Genres,
+			&movie.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		return recordRevision(ctx, tx, RevisionRestore, id)
+	})
+
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
+	return &movie, nil
+}
+
+// Purge permanently deletes the movie, whether or not it has been soft deleted. Its revisions are
+// kept, and the purge itself is recorded as a final revision so the audit trail shows who removed it.
+func (m MovieModel) Purge(ctx context.Context, id int64) error {
+	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	return nil
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, m.DB, func(tx pgx.Tx) error {
+		ids, err := purgeMovies(ctx, tx, `id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		if len(ids) == 0 {
+			return ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// PurgeDeletedBefore permanently deletes every movie soft deleted before the cutoff and returns
+// the number of movies removed. As with Purge, each removal is recorded as a revision.
+func (m MovieModel) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var purged int64
+
+	err := pgx.BeginFunc(ctx, m.DB, func(tx pgx.Tx) error {
+		ids, err := purgeMovies(ctx, tx, `deleted_at IS NOT NULL AND deleted_at < $1`, cutoff)
+		purged = int64(len(ids))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+// purgeMovies deletes the movies matching the condition within tx and returns their IDs. The
+// version of each movie is bumped and recorded as a purge revision first, as the revision is a
+// snapshot of the row and so has to be taken while the row still exists.
+func purgeMovies(ctx context.Context, tx pgx.Tx, condition string, args ...interface{}) ([]int64, error) {
+	query := fmt.Sprintf(`
+		UPDATE movies
+		SET version = version + 1
+		WHERE %s
+		RETURNING id
+	`, condition)
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	err = recordRevision(ctx, tx, RevisionPurge, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM movies WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
 }
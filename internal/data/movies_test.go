@@ -0,0 +1,85 @@
+package data
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emmasela/greenlight/internal/validator"
+)
+
+func TestValidateMovie(t *testing.T) {
+	valid := func() *Movie {
+		return &Movie{Title: "Casablanca", Year: 1942, Runtime: 102, Genres: []string{"drama", "romance"}}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Movie)
+		want   []string // "field code" pairs
+	}{
+		{
+			name:   "valid",
+			modify: func(m *Movie) {},
+		},
+		{
+			name:   "missing fields",
+			modify: func(m *Movie) { *m = Movie{} },
+			want:   []string{"genres required", "runtime required", "title required", "year required"},
+		},
+		{
+			name:   "title too long",
+			modify: func(m *Movie) { m.Title = strings.Repeat("a", 501) },
+			want:   []string{"title max_length"},
+		},
+		{
+			name:   "year too early",
+			modify: func(m *Movie) { m.Year = 1887 },
+			want:   []string{"year out_of_range"},
+		},
+		{
+			name:   "year in the future",
+			modify: func(m *Movie) { m.Year = int32(time.Now().Year() + 1) },
+			want:   []string{"year out_of_range"},
+		},
+		{
+			name:   "too many genres",
+			modify: func(m *Movie) { m.Genres = []string{"a", "b", "c", "d", "e", "f"} },
+			want:   []string{"genres max_items"},
+		},
+		{
+			name:   "duplicate genres",
+			modify: func(m *Movie) { m.Genres = []string{"drama", "drama"} },
+			want:   []string{"genres duplicate", "genres[1] duplicate"},
+		},
+		{
+			name:   "empty genre",
+			modify: func(m *Movie) { m.Genres = []string{"drama", ""} },
+			want:   []string{"genres[1] required"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie := valid()
+			tt.modify(movie)
+
+			v := validator.New()
+			ValidateMovie(v, movie)
+
+			var got []string
+			for field, fieldErrors := range v.FieldErrors {
+				for _, fieldError := range fieldErrors {
+					got = append(got, field+" "+fieldError.Code)
+				}
+			}
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got errors %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// The operations recorded in the movie_revisions audit trail
+const (
+	RevisionInsert  = "insert"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+	// RevisionPurge is the last revision of a movie, recorded as it is permanently deleted
+	RevisionPurge = "purge"
+	// RevisionSnapshot marks the baseline revisions recorded for movies which existed before the
+	// audit trail was introduced
+	RevisionSnapshot = "snapshot"
+)
+
+// Actor identifies who is responsible for the changes made with a context. A zero UserID means the
+// change was not made on behalf of a user (e.g. by a background job).
+type Actor struct {
+	UserID    int64
+	RequestID string
+}
+
+type actorContextKey struct{}
+
+// ContextWithActor returns a copy of ctx carrying the actor, so that changes made through the
+// models with it are attributed to them in the audit trail.
+func ContextWithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// actorFromContext returns the actor carried by ctx, or a zero Actor if there is none.
+func actorFromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorContextKey{}).(Actor)
+	return actor
+}
+
+// MovieRevision is a snapshot of a movie as it was at a particular version, along with who made
+// the change and when.
+type MovieRevision struct {
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	Operation string    `json:"operation"`
+	Movie     Movie     `json:"movie"`
+	UserID    *int64    `json:"user_id"`
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FieldChange describes the change to a single field between two versions of a movie
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// DiffMovies returns the fields which differ between two versions of a movie, in a fixed order.
+// An empty (non-nil) slice is returned if the versions are identical.
+func DiffMovies(from, to *Movie) []FieldChange {
+	changes := []FieldChange{}
+
+	if from.Title != to.Title {
+		changes = append(changes, FieldChange{Field: "title", From: from.Title, To: to.Title})
+	}
+
+	if from.Year != to.Year {
+		changes = append(changes, FieldChange{Field: "year", From: from.Year, To: to.Year})
+	}
+
+	if from.Runtime != to.Runtime {
+		changes = append(changes, FieldChange{Field: "runtime", From: from.Runtime, To: to.Runtime})
+	}
+
+	if !slices.Equal(from.Genres, to.Genres) {
+		changes = append(changes, FieldChange{Field: "genres", From: from.Genres, To: to.Genres})
+	}
+
+	return changes
+}
+
+// recordRevision snapshots the current state of the movies into movie_revisions. It must be called
+// with the transaction that made the change, after the change, so the revisions are only stored
+// if the change is committed.
+func recordRevision(ctx context.Context, tx pgx.Tx, operation string, movieIDs ...int64) error {
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, operation, snapshot, user_id, request_id)
+		SELECT id, version, $2,
+			jsonb_build_object('id', id, 'title', title, 'year', year, 'runtime', runtime,
+				'genres', genres, 'version', version),
+			NULLIF($3::bigint, 0), NULLIF($4, '')
+		FROM movies
+		WHERE id = ANY($1)
+	`
+
+	actor := actorFromContext(ctx)
+
+	_, err := tx.Exec(ctx, query, movieIDs, operation, actor.UserID, actor.RequestID)
+	return err
+}
+
+// GetRevisions returns a page of the revisions of a movie, including those made before it was
+// soft deleted. ErrRecordNotFound is returned if the movie has no revisions at all.
+func (m MovieModel) GetRevisions(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	if movieID < 1 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), movie_id, version, operation, snapshot, user_id,
+			COALESCE(request_id, ''), created_at
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Operation,
+			&revision.Movie,
+			&revision.UserID,
+			&revision.RequestID,
+			&revision.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Every movie has at least one revision, so an empty first page means there is no such movie
+	if totalRecords == 0 && filters.Page == 1 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// GetRevision returns the revision of a movie at the given version
+func (m MovieModel) GetRevision(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT movie_id, version, operation, snapshot, user_id, COALESCE(request_id, ''), created_at
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2
+	`
+
+	var revision MovieRevision
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, movieID, version).Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Operation,
+		&revision.Movie,
+		&revision.UserID,
+		&revision.RequestID,
+		&revision.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// Revert saves a movie whose fields have been set back to those of an earlier revision as a new
+// version, so the history itself is never rewritten. It differs from Update only in the operation
+// recorded against the revision. As with Update, ErrEditConflict is returned if the movie has
+// changed since it was read.
+func (m MovieModel) Revert(ctx context.Context, movie *Movie) error {
+	return m.update(ctx, movie, RevisionRevert)
+}
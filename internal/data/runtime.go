@@ -1,18 +1,148 @@
 package data
 
 import (
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
-// Runtime type for runtime of a movie
+// Runtime type for runtime of a movie, in minutes
 type Runtime int32
 
 // ErrInvalidRuntimeFormat An error which is returned if UnmarshalJSON() can't parse JSON string successfully
 var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
 
+// RuntimeFormat selects how a Runtime is rendered in JSON responses
+type RuntimeFormat string
+
+const (
+	// RuntimeFormatMinutes renders "102 mins" (the default)
+	RuntimeFormatMinutes RuntimeFormat = "minutes"
+	// RuntimeFormatInteger renders 102
+	RuntimeFormatInteger RuntimeFormat = "integer"
+	// RuntimeFormatISO8601 renders "PT1H42M"
+	RuntimeFormatISO8601 RuntimeFormat = "iso8601"
+	// RuntimeFormatHuman renders "1h 42m"
+	RuntimeFormatHuman RuntimeFormat = "human"
+)
+
+// RuntimeFormats lists every supported RuntimeFormat
+var RuntimeFormats = []string{
+	string(RuntimeFormatMinutes),
+	string(RuntimeFormatInteger),
+	string(RuntimeFormatISO8601),
+	string(RuntimeFormatHuman),
+}
+
+var (
+	minutesRX = regexp.MustCompile(`^(\d+)\s*(?:mins?|minutes?|m)?$`)
+	iso8601RX = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+	humanRX   = regexp.MustCompile(`^(?:(\d+)\s*(?:h|hrs?|hours?))?\s*(?:(\d+)\s*(?:m|mins?|minutes?))?$`)
+)
+
+// ParseRuntime parses a runtime given as a number of minutes ("102", "102 mins", "102 min"),
+// in hours and minutes ("1h 42m", "1h42m", "2h") or as an ISO 8601 duration ("PT1H42M").
+// ISO 8601 durations must amount to a whole number of minutes.
+func ParseRuntime(s string) (Runtime, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	if matches := minutesRX.FindStringSubmatch(s); matches != nil {
+		return runtimeFromParts(matches[1], "", "")
+	}
+
+	if upper := strings.ToUpper(s); upper != "PT" {
+		if matches := iso8601RX.FindStringSubmatch(upper); matches != nil {
+			return runtimeFromParts(matches[2], matches[1], matches[3])
+		}
+	}
+
+	if matches := humanRX.FindStringSubmatch(strings.ToLower(s)); matches != nil {
+		return runtimeFromParts(matches[2], matches[1], "")
+	}
+
+	return 0, ErrInvalidRuntimeFormat
+}
+
+// runtimeFromParts adds up the (possibly empty) minutes, hours and seconds components.
+func runtimeFromParts(minutes, hours, seconds string) (Runtime, error) {
+	var total int64
+
+	for _, part := range []struct {
+		value   string
+		seconds int64
+	}{{minutes, 60}, {hours, 3600}, {seconds, 1}} {
+		if part.value == "" {
+			continue
+		}
+
+		n, err := strconv.ParseInt(part.value, 10, 32)
+		if err != nil {
+			return 0, ErrInvalidRuntimeFormat
+		}
+
+		total += n * part.seconds
+	}
+
+	if total%60 != 0 || total/60 > math.MaxInt32 {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	return Runtime(total / 60), nil
+}
+
+// ParseRuntimeFormat returns the RuntimeFormat with the given name
+func ParseRuntimeFormat(s string) (RuntimeFormat, error) {
+	for _, format := range RuntimeFormats {
+		if s == format {
+			return RuntimeFormat(s), nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown runtime format %q", s)
+}
+
+// Format returns the runtime in the given format, as a value ready to be encoded to JSON
+func (r Runtime) Format(format RuntimeFormat) interface{} {
+	switch format {
+	case RuntimeFormatInteger:
+		return int32(r)
+
+	case RuntimeFormatISO8601:
+		hours, minutes := r/60, r%60
+		switch {
+		case hours == 0:
+			return fmt.Sprintf("PT%dM", minutes)
+		case minutes == 0:
+			return fmt.Sprintf("PT%dH", hours)
+		default:
+			return fmt.Sprintf("PT%dH%dM", hours, minutes)
+		}
+
+	case RuntimeFormatHuman:
+		hours, minutes := r/60, r%60
+		switch {
+		case hours == 0:
+			return fmt.Sprintf("%dm", minutes)
+		case minutes == 0:
+			return fmt.Sprintf("%dh", hours)
+		default:
+			return fmt.Sprintf("%dh %dm", hours, minutes)
+		}
+
+	default:
+		return fmt.Sprintf("%d mins", r)
+	}
+}
+
 // MarshalJSON method for the Runtime type
 func (r Runtime) MarshalJSON() ([]byte, error) {
 	jsonValue := fmt.Sprintf("%d mins", r)
@@ -24,24 +154,84 @@ func (r Runtime) MarshalJSON() ([]byte, error) {
 	return []byte(quotedJSONValue), nil
 }
 
+// UnmarshalJSON accepts a runtime either as a JSON number of minutes or as a string in any of
+// the forms understood by ParseRuntime.
 func (r *Runtime) UnmarshalJSON(data []byte) error {
-	unquotedJSONValue, err := strconv.Unquote(string(data))
-	if err != nil {
-		return ErrInvalidRuntimeFormat
+	// Leave the value untouched for null, as is conventional
+	if string(data) == "null" {
+		return nil
 	}
 
-	parts := strings.Split(unquotedJSONValue, " ")
+	if len(data) > 0 && data[0] != '"' {
+		i, err := strconv.ParseInt(string(data), 10, 32)
+		if err != nil {
+			return ErrInvalidRuntimeFormat
+		}
 
-	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
+		*r = Runtime(i)
+		return nil
 	}
 
-	i, err := strconv.ParseInt(parts[0], 10, 32)
+	unquotedJSONValue, err := strconv.Unquote(string(data))
 	if err != nil {
 		return ErrInvalidRuntimeFormat
 	}
 
-	*r = Runtime(i)
+	return r.UnmarshalText([]byte(unquotedJSONValue))
+}
+
+// MarshalText implements encoding.TextMarshaler, using the "102 mins" format
+func (r Runtime) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d mins", r)), nil
+}
 
+// UnmarshalText implements encoding.TextUnmarshaler, accepting any format understood by ParseRuntime
+func (r *Runtime) UnmarshalText(text []byte) error {
+	runtime, err := ParseRuntime(string(text))
+	if err != nil {
+		return err
+	}
+
+	*r = runtime
 	return nil
 }
+
+// Scan implements sql.Scanner, reading the runtime from an integer or text column
+func (r *Runtime) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case int64:
+		if src < math.MinInt32 || src > math.MaxInt32 {
+			return fmt.Errorf("runtime %d out of range", src)
+		}
+		*r = Runtime(src)
+		return nil
+	case int32:
+		*r = Runtime(src)
+		return nil
+	case []byte:
+		return r.UnmarshalText(src)
+	case string:
+		return r.UnmarshalText([]byte(src))
+	default:
+		return fmt.Errorf("cannot scan %T into Runtime", src)
+	}
+}
+
+// Value implements driver.Valuer, storing the runtime as an integer number of minutes
+func (r Runtime) Value() (driver.Value, error) {
+	return int64(r), nil
+}
+
+// ScanInt64 implements pgtype.Int64Scanner so pgx can scan integer columns straight into a Runtime
+func (r *Runtime) ScanInt64(v pgtype.Int8) error {
+	if !v.Valid {
+		return errors.New("cannot scan NULL into Runtime")
+	}
+
+	return r.Scan(v.Int64)
+}
+
+// Int64Value implements pgtype.Int64Valuer so pgx can encode a Runtime as an integer
+func (r Runtime) Int64Value() (pgtype.Int8, error) {
+	return pgtype.Int8{Int64: int64(r), Valid: true}, nil
+}
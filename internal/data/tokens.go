@@ -0,0 +1,96 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"time"
+
+	"github.com/emmasela/greenlight/internal/validator"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Token scopes
+const (
+	ScopeActivation     = "activation"
+	ScopeAuthentication = "authentication"
+)
+
+// Token holds a plaintext token (only ever sent to the user, never stored) along with
+// the SHA-256 hash of it that gets persisted in the database.
+type Token struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	UserID    int64     `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+}
+
+// generateToken creates a token for the user with the given time-to-live and scope. The
+// plaintext is 16 random bytes encoded as a 26 character base-32 string.
+func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token := &Token{
+		UserID: userID,
+		Expiry: time.Now().Add(ttl),
+		Scope:  scope,
+	}
+
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token, nil
+}
+
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.CheckCode(tokenPlaintext != "", "token", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(len(tokenPlaintext) == 26, "token", validator.CodeFormat, "must be 26 bytes long",
+		map[string]interface{}{"length": 26})
+}
+
+type TokenModel struct {
+	DB *pgxpool.Pool
+}
+
+// New generates a token for the user and inserts it into the tokens table
+func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(token)
+	return token, err
+}
+
+func (m TokenModel) Insert(token *Token) error {
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+
+	_, err := m.DB.Exec(context.Background(), query, args...)
+	return err
+}
+
+// DeleteAllForUser removes every token with the given scope belonging to the user
+func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE scope = $1 AND user_id = $2
+	`
+
+	_, err := m.DB.Exec(context.Background(), query, scope, userID)
+	return err
+}
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/emmasela/greenlight/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrDuplicateEmail A custom error which is returned when a user with the same email already exists
+// ErrPasswordTooLong An error which is returned when a password is too long for bcrypt to hash
+var (
+	ErrDuplicateEmail  = errors.New("duplicate email")
+	ErrPasswordTooLong = bcrypt.ErrPasswordTooLong
+)
+
+// AnonymousUser represents a client that has not authenticated
+var AnonymousUser = &User{}
+
+type User struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Password  password  `json:"-"`
+	Activated bool      `json:"activated"`
+	Version   int       `json:"-"`
+}
+
+// IsAnonymous reports whether the user is the AnonymousUser sentinel
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+// password holds the plaintext password (only available while handling the request that set it)
+// and its bcrypt hash.
+type password struct {
+	plaintext *string
+	hash      []byte
+}
+
+// Set calculates the bcrypt hash of a plaintext password and stores both values in the struct.
+// Passwords over 72 bytes can't be hashed, in which case ErrPasswordTooLong is returned but the
+// plaintext is still stored, so that ValidateUser reports the problem to the client.
+func (p *password) Set(plaintextPassword string) error {
+	p.plaintext = &plaintextPassword
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	if err != nil {
+		return err
+	}
+
+	p.hash = hash
+
+	return nil
+}
+
+// Matches checks whether the provided plaintext password matches the stored hash
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+type UserModel struct {
+	DB *pgxpool.Pool
+}
+
+func ValidateEmail(v *validator.Validator, email string) {
+	v.CheckCode(email != "", "email", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(validator.Matches(email, validator.EmailRX), "email", validator.CodeFormat, "must be a valid email address",
+		map[string]interface{}{"format": "email"})
+}
+
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.CheckCode(password != "", "password", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(len(password) >= 8, "password", validator.CodeMinLength, "must be at least 8 bytes long",
+		map[string]interface{}{"min": 8})
+	v.CheckCode(len(password) <= 72, "password", validator.CodeMaxLength, "must not be more than 72 bytes long",
+		map[string]interface{}{"max": 72})
+}
+
+func ValidateUser(v *validator.Validator, user *User) {
+	v.CheckCode(user.Name != "", "name", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(len(user.Name) <= 500, "name", validator.CodeMaxLength, "must not be more than 500 bytes long",
+		map[string]interface{}{"max": 500})
+
+	ValidateEmail(v, user.Email)
+
+	if user.Password.plaintext != nil {
+		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+	}
+
+	// A missing hash for an otherwise valid password means we've made a logic error in our code,
+	// not that the client did
+	if user.Password.hash == nil && v.Valid() {
+		panic("missing password hash for user")
+	}
+}
+
+// Insert creates a new user record. If a user with the same email already exists,
+// ErrDuplicateEmail is returned.
+func (m UserModel) Insert(user *User) error {
+	query := `
+		INSERT INTO users (name, email, password_hash, activated)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version
+	`
+
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
+
+	err := m.DB.QueryRow(context.Background(), query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgErr) && pgErr.ConstraintName == "users_email_key":
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m UserModel) GetByEmail(email string) (*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE email = $1
+	`
+
+	var user User
+
+	err := m.DB.QueryRow(context.Background(), query, email).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// Update saves the user, provided its version still matches the one stored in the database.
+// ErrDuplicateEmail is returned if the new email is taken, and ErrEditConflict if the record
+// was changed in the meantime.
+func (m UserModel) Update(user *User) error {
+	query := `
+		UPDATE users
+		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING version
+	`
+
+	args := []interface{}{
+		user.Name,
+		user.Email,
+		user.Password.hash,
+		user.Activated,
+		user.ID,
+		user.Version,
+	}
+
+	err := m.DB.QueryRow(context.Background(), query, args...).Scan(&user.Version)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgErr) && pgErr.ConstraintName == "users_email_key":
+			return ErrDuplicateEmail
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetForToken returns the user owning the given unexpired token of the given scope
+func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+		FROM users
+		INNER JOIN tokens
+		ON users.id = tokens.user_id
+		WHERE tokens.hash = $1
+		AND tokens.scope = $2
+		AND tokens.expiry > $3
+	`
+
+	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
+
+	var user User
+
+	err := m.DB.QueryRow(context.Background(), query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,330 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lockID is the key of the Postgres advisory lock taken while migrating, so that two instances
+// starting at the same time can't run migrations concurrently.
+const lockID = 4_815_162_342
+
+var (
+	// ErrDirty is returned when a previous migration run was interrupted and the schema has to
+	// be repaired by hand before migrating again (see Force).
+	ErrDirty = errors.New("database schema is dirty, fix it and force a version")
+	// ErrNoChange is returned when the schema is already at the requested version.
+	ErrNoChange = errors.New("no change")
+	// ErrUnknownVersion is returned when a requested version, or the version the database is
+	// currently at, has no migration files.
+	ErrUnknownVersion = errors.New("unknown migration version")
+)
+
+// fileRX matches migration file names such as "000001_create_movies_table.up.sql"
+var fileRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration holds the SQL to apply and to roll back a single schema version
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator applies migrations to the database, recording the current version in the
+// schema_migrations table (which uses the same layout as golang-migrate).
+type Migrator struct {
+	db         *pgxpool.Pool
+	migrations []Migration
+}
+
+// New reads the migration files from the root of fsys. Every version must have both an up and
+// a down file.
+func New(db *pgxpool.Pool, fsys fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		matches := fileRX.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+
+		contents, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = migration
+		}
+
+		if matches[3] == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	m := &Migrator{db: db}
+
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) must have both up and down files", migration.Version, migration.Name)
+		}
+		m.migrations = append(m.migrations, *migration)
+	}
+
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+
+	return m, nil
+}
+
+// Latest returns the highest available migration version, or 0 if there are none
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version the database schema is currently at, and whether the last migration
+// run left it dirty. A database which has never been migrated is at version 0.
+func (m *Migrator) Version(ctx context.Context) (int64, bool, error) {
+	var exists bool
+
+	err := m.db.QueryRow(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+
+	return currentVersion(ctx, m.db)
+}
+
+// Up applies every migration newer than the current version
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Goto(ctx, m.Latest())
+}
+
+// Down rolls back the given number of migrations
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.run(ctx, func(current int64) (int64, error) {
+		i := m.index(current)
+		if i-steps < 0 {
+			return 0, nil
+		}
+		return m.migrations[i-steps].Version, nil
+	})
+}
+
+// Goto migrates up or down to the given version. Version 0 rolls back every migration.
+func (m *Migrator) Goto(ctx context.Context, version int64) error {
+	if version != 0 && m.index(version) < 0 {
+		return ErrUnknownVersion
+	}
+
+	return m.run(ctx, func(int64) (int64, error) {
+		return version, nil
+	})
+}
+
+// Force records the given version as the current one and clears the dirty flag without running
+// any migrations. It is used to recover after fixing a failed migration by hand.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	if version != 0 && m.index(version) < 0 {
+		return ErrUnknownVersion
+	}
+
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		err = setVersion(ctx, tx, version)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit(ctx)
+	})
+}
+
+// run works out the target version from the current one and applies the migrations in between,
+// each in its own transaction.
+func (m *Migrator) run(ctx context.Context, target func(current int64) (int64, error)) error {
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		current, dirty, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if dirty {
+			return ErrDirty
+		}
+
+		// The database may have been migrated by a newer binary. Without the files for its current
+		// version there's no way to roll it back, and no safe way to work out the target either.
+		if current != 0 && m.index(current) < 0 {
+			return fmt.Errorf("database is at version %d: %w", current, ErrUnknownVersion)
+		}
+
+		version, err := target(current)
+		if err != nil {
+			return err
+		}
+
+		if version == current {
+			return ErrNoChange
+		}
+
+		if version > current {
+			for _, migration := range m.migrations {
+				if migration.Version > current && migration.Version <= version {
+					err = apply(ctx, conn, migration.Up, migration.Version)
+					if err != nil {
+						return fmt.Errorf("migration %d (%s) up: %w", migration.Version, migration.Name, err)
+					}
+				}
+			}
+			return nil
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version > version && migration.Version <= current {
+				previous := int64(0)
+				if i > 0 {
+					previous = m.migrations[i-1].Version
+				}
+
+				err = apply(ctx, conn, migration.Down, previous)
+				if err != nil {
+					return fmt.Errorf("migration %d (%s) down: %w", migration.Version, migration.Name, err)
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// withLock acquires a dedicated connection, makes sure the schema_migrations table exists and
+// holds the migration advisory lock on that connection while fn runs.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// index returns the position of the version in the sorted migrations, or -1 if it is unknown
+func (m *Migrator) index(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// querier is satisfied by the pool, pooled connections and transactions
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+func currentVersion(ctx context.Context, q querier) (int64, bool, error) {
+	var (
+		version int64
+		dirty   bool
+	)
+
+	err := q.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
+
+// apply runs the migration SQL and records the resulting version in a single transaction, so a
+// failure leaves the schema exactly as it was.
+func apply(ctx context.Context, conn *pgxpool.Conn, sql string, version int64) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, sql)
+	if err != nil {
+		return err
+	}
+
+	err = setVersion(ctx, tx, version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func setVersion(ctx context.Context, tx pgx.Tx, version int64) error {
+	_, err := tx.Exec(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	if version == 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, version)
+	return err
+}
@@ -0,0 +1,277 @@
+package patch
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decode unmarshals JSON text into a generic value, failing the test if it is invalid
+func decode(t *testing.T, s string) interface{} {
+	t.Helper()
+
+	var value interface{}
+
+	err := json.Unmarshal([]byte(s), &value)
+	if err != nil {
+		t.Fatalf("invalid JSON %s: %v", s, err)
+	}
+
+	return value
+}
+
+// The cases marked A.n are the examples from RFC 6902 Appendix A
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		ops     string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "A.1 adding an object member",
+			doc:  `{"foo": "bar"}`,
+			ops:  `[{"op": "add", "path": "/baz", "value": "qux"}]`,
+			want: `{"baz": "qux", "foo": "bar"}`,
+		},
+		{
+			name: "A.2 adding an array element",
+			doc:  `{"foo": ["bar", "baz"]}`,
+			ops:  `[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			want: `{"foo": ["bar", "qux", "baz"]}`,
+		},
+		{
+			name: "A.3 removing an object member",
+			doc:  `{"baz": "qux", "foo": "bar"}`,
+			ops:  `[{"op": "remove", "path": "/baz"}]`,
+			want: `{"foo": "bar"}`,
+		},
+		{
+			name: "A.4 removing an array element",
+			doc:  `{"foo": ["bar", "qux", "baz"]}`,
+			ops:  `[{"op": "remove", "path": "/foo/1"}]`,
+			want: `{"foo": ["bar", "baz"]}`,
+		},
+		{
+			name: "A.5 replacing a value",
+			doc:  `{"baz": "qux", "foo": "bar"}`,
+			ops:  `[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+			want: `{"baz": "boo", "foo": "bar"}`,
+		},
+		{
+			name: "A.6 moving a value",
+			doc:  `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			ops:  `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			want: `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+		},
+		{
+			name: "A.7 moving an array element",
+			doc:  `{"foo": ["all", "grass", "cows", "eat"]}`,
+			ops:  `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+			want: `{"foo": ["all", "cows", "eat", "grass"]}`,
+		},
+		{
+			name: "A.8 testing a value: success",
+			doc:  `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			ops:  `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			want: `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+		},
+		{
+			name:    "A.9 testing a value: error",
+			doc:     `{"baz": "qux"}`,
+			ops:     `[{"op": "test", "path": "/baz", "value": "bar"}]`,
+			wantErr: ErrTestFailed.Error(),
+		},
+		{
+			name: "A.10 adding a nested member object",
+			doc:  `{"foo": "bar"}`,
+			ops:  `[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`,
+			want: `{"foo": "bar", "child": {"grandchild": {}}}`,
+		},
+		{
+			name: "A.11 ignoring unrecognized elements",
+			doc:  `{"foo": "bar"}`,
+			ops:  `[{"op": "add", "path": "/baz", "value": "qux", "xyz": 123}]`,
+			want: `{"foo": "bar", "baz": "qux"}`,
+		},
+		{
+			name:    "A.12 adding to a nonexistent target",
+			doc:     `{"foo": "bar"}`,
+			ops:     `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+			wantErr: ErrPathNotFound.Error(),
+		},
+		{
+			name: "A.14 ~ escape ordering",
+			doc:  `{"/": 9, "~1": 10}`,
+			ops:  `[{"op": "test", "path": "/~01", "value": 10}]`,
+			want: `{"/": 9, "~1": 10}`,
+		},
+		{
+			name:    "A.15 comparing strings and numbers",
+			doc:     `{"/": 9, "~1": 10}`,
+			ops:     `[{"op": "test", "path": "/~01", "value": "10"}]`,
+			wantErr: ErrTestFailed.Error(),
+		},
+		{
+			name: "A.16 adding an array value",
+			doc:  `{"foo": ["bar"]}`,
+			ops:  `[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`,
+			want: `{"foo": ["bar", ["abc", "def"]]}`,
+		},
+		{
+			name: "add at the index one past the end",
+			doc:  `{"foo": ["bar"]}`,
+			ops:  `[{"op": "add", "path": "/foo/1", "value": "baz"}]`,
+			want: `{"foo": ["bar", "baz"]}`,
+		},
+		{
+			name:    "add beyond the end",
+			doc:     `{"foo": ["bar"]}`,
+			ops:     `[{"op": "add", "path": "/foo/2", "value": "baz"}]`,
+			wantErr: ErrPathNotFound.Error(),
+		},
+		{
+			name:    "remove the end of an array",
+			doc:     `{"foo": ["bar"]}`,
+			ops:     `[{"op": "remove", "path": "/foo/-"}]`,
+			wantErr: "invalid array index",
+		},
+		{
+			name:    "index with a leading zero",
+			doc:     `{"foo": ["bar", "baz"]}`,
+			ops:     `[{"op": "remove", "path": "/foo/01"}]`,
+			wantErr: "invalid array index",
+		},
+		{
+			name:    "move into a child",
+			doc:     `{"a": {"b": 1}}`,
+			ops:     `[{"op": "move", "from": "/a", "path": "/a/c"}]`,
+			wantErr: "cannot move a value into one of its children",
+		},
+		{
+			name: "move to a sibling sharing a prefix",
+			doc:  `{"a": 1}`,
+			ops:  `[{"op": "move", "from": "/a", "path": "/ab"}]`,
+			want: `{"ab": 1}`,
+		},
+		{
+			name: "move to the same location",
+			doc:  `{"a": 1}`,
+			ops:  `[{"op": "move", "from": "/a", "path": "/a"}]`,
+			want: `{"a": 1}`,
+		},
+		{
+			name: "replace the document root",
+			doc:  `{"a": 1}`,
+			ops:  `[{"op": "replace", "path": "", "value": {"b": 2}}]`,
+			want: `{"b": 2}`,
+		},
+		{
+			name:    "replace a missing value",
+			doc:     `{"a": 1}`,
+			ops:     `[{"op": "replace", "path": "/b", "value": 2}]`,
+			wantErr: ErrPathNotFound.Error(),
+		},
+		{
+			name:    "remove the document root",
+			doc:     `{"a": 1}`,
+			ops:     `[{"op": "remove", "path": ""}]`,
+			wantErr: "cannot remove the whole document",
+		},
+		{
+			name: "explicit null values",
+			doc:  `{"a": 1}`,
+			ops:  `[{"op": "add", "path": "/b", "value": null}, {"op": "test", "path": "/b", "value": null}]`,
+			want: `{"a": 1, "b": null}`,
+		},
+		{
+			name:    "missing value",
+			doc:     `{"a": 1}`,
+			ops:     `[{"op": "add", "path": "/b"}]`,
+			wantErr: "missing value",
+		},
+		{
+			name: "copied values are independent",
+			doc:  `{"a": {"b": 1}}`,
+			ops:  `[{"op": "copy", "from": "/a", "path": "/c"}, {"op": "add", "path": "/c/d", "value": 2}]`,
+			want: `{"a": {"b": 1}, "c": {"b": 1, "d": 2}}`,
+		},
+		{
+			name:    "invalid pointer",
+			doc:     `{"a": 1}`,
+			ops:     `[{"op": "remove", "path": "a"}]`,
+			wantErr: "invalid JSON pointer",
+		},
+		{
+			name:    "unknown operation",
+			doc:     `{"a": 1}`,
+			ops:     `[{"op": "frobnicate", "path": "/a"}]`,
+			wantErr: "unknown operation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []Operation
+
+			err := json.Unmarshal([]byte(tt.ops), &ops)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Apply(decode(t, tt.doc), ops)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v; want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+// The cases are the examples from RFC 7396 Appendix A
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		{doc: `{"a": "b"}`, patch: `{"a": "c"}`, want: `{"a": "c"}`},
+		{doc: `{"a": "b"}`, patch: `{"b": "c"}`, want: `{"a": "b", "b": "c"}`},
+		{doc: `{"a": "b"}`, patch: `{"a": null}`, want: `{}`},
+		{doc: `{"a": "b", "b": "c"}`, patch: `{"a": null}`, want: `{"b": "c"}`},
+		{doc: `{"a": ["b"]}`, patch: `{"a": "c"}`, want: `{"a": "c"}`},
+		{doc: `{"a": "c"}`, patch: `{"a": ["b"]}`, want: `{"a": ["b"]}`},
+		{doc: `{"a": {"b": "c"}}`, patch: `{"a": {"b": "d", "c": null}}`, want: `{"a": {"b": "d"}}`},
+		{doc: `{"a": [{"b": "c"}]}`, patch: `{"a": [1]}`, want: `{"a": [1]}`},
+		{doc: `["a", "b"]`, patch: `["c", "d"]`, want: `["c", "d"]`},
+		{doc: `{"a": "b"}`, patch: `["c"]`, want: `["c"]`},
+		{doc: `{"a": "foo"}`, patch: `null`, want: `null`},
+		{doc: `{"a": "foo"}`, patch: `"bar"`, want: `"bar"`},
+		{doc: `{"e": null}`, patch: `{"a": 1}`, want: `{"e": null, "a": 1}`},
+		{doc: `[1, 2]`, patch: `{"a": "b", "c": null}`, want: `{"a": "b"}`},
+		{doc: `{}`, patch: `{"a": {"bb": {"ccc": null}}}`, want: `{"a": {"bb": {}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.doc+" "+tt.patch, func(t *testing.T) {
+			got := Merge(decode(t, tt.doc), decode(t, tt.patch))
+
+			if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		})
+	}
+}
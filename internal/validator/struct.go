@@ -0,0 +1,431 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RuleFunc reports whether the value satisfies a custom validation rule. The param is whatever
+// followed the "=" in the tag (e.g. "3" for `validate:"divisible=3"`), or an empty string.
+type RuleFunc func(value reflect.Value, param string) bool
+
+// customRule is a rule registered with RegisterRule
+type customRule struct {
+	code    string
+	message string
+	fn      RuleFunc
+}
+
+var (
+	customRulesMu sync.RWMutex
+	customRules   = make(map[string]customRule)
+
+	// structCache holds the parsed *structMeta for each struct type, so the tags of a type are
+	// only parsed the first time one of its values is validated
+	structCache sync.Map
+)
+
+// RegisterRule makes a custom rule available to struct tags under the given name. Failures are
+// reported with the given code and message. Rules should be registered before the first struct
+// using them is validated, typically from an init function.
+func RegisterRule(name, code, message string, fn RuleFunc) {
+	customRulesMu.Lock()
+	defer customRulesMu.Unlock()
+
+	customRules[name] = customRule{code: code, message: message, fn: fn}
+}
+
+// rule is a single parsed entry of a validate tag
+type rule struct {
+	name   string
+	param  string
+	number float64 // param parsed as a number, for min and max
+}
+
+// fieldMeta holds the parsed validation rules for a struct field
+type fieldMeta struct {
+	index int
+	name  string
+	rules []rule
+	// dive holds the rules following "dive", which apply to each element of a slice or array
+	dive []rule
+}
+
+type structMeta struct {
+	fields []fieldMeta
+}
+
+// Struct validates the fields of a struct (or pointer to a struct) against their `validate` tags
+// and records any failures in the Validator. Supported rules are:
+//
+//	required      the value must not be the zero value (or nil)
+//	min=N, max=N  bounds on the length of strings, the number of items in slices and maps,
+//	              or the value of numbers
+//	oneof=a b c   the value must be one of the space separated values
+//	unique        slices must not contain duplicate values (their elements must be comparable)
+//	email         strings must be valid email addresses
+//	dive          the rules that follow apply to each element of a slice
+//
+// as well as any custom rules added with RegisterRule. Fields are named after their json tag,
+// and nested structs are validated with paths such as "address.city". Zero values which aren't
+// required skip the remaining rules.
+func (v *Validator) Struct(s interface{}) {
+	v.validateStruct("", reflect.ValueOf(s))
+}
+
+func (v *Validator) validateStruct(path string, value reflect.Value) {
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validator: Struct called with non-struct type %s", value.Type()))
+	}
+
+	meta := getStructMeta(value.Type())
+
+	for _, field := range meta.fields {
+		fieldValue := value.Field(field.index)
+		key := Nested(path, field.name)
+
+		if !v.applyRules(key, fieldValue, field.rules) {
+			continue
+		}
+
+		if field.dive != nil && (fieldValue.Kind() == reflect.Slice || fieldValue.Kind() == reflect.Array) {
+			for i := 0; i < fieldValue.Len(); i++ {
+				elemKey := Index(key, i)
+				elem := fieldValue.Index(i)
+
+				if v.applyRules(elemKey, elem, field.dive) && isStruct(elem) {
+					v.validateStruct(elemKey, elem)
+				}
+			}
+			continue
+		}
+
+		if isStruct(fieldValue) {
+			v.validateStruct(key, fieldValue)
+		}
+	}
+}
+
+// applyRules checks the value against each rule in turn, recording every failure. It returns false
+// if the value is zero, in which case there is nothing further (elements or nested fields) to check.
+// The exception is a zero struct, whose own fields may still be required.
+func (v *Validator) applyRules(key string, value reflect.Value, rules []rule) bool {
+	if isZero(value) {
+		for _, r := range rules {
+			if r.name == "required" {
+				v.AddFieldError(key, CodeRequired, "must be provided", nil)
+			}
+		}
+		return value.Kind() == reflect.Struct
+	}
+
+	for _, r := range rules {
+		switch r.name {
+		case "required":
+
+		case "min", "max":
+			v.checkBound(key, value, r)
+
+		case "oneof":
+			allowed := strings.Fields(r.param)
+			if !In(fmt.Sprint(indirect(value).Interface()), allowed...) {
+				v.AddFieldError(key, CodeInvalid, "must be one of: "+strings.Join(allowed, ", "),
+					map[string]interface{}{"allowed": allowed})
+			}
+
+		case "unique":
+			if duplicates := duplicateValues(value); len(duplicates) > 0 {
+				v.AddFieldError(key, CodeDuplicate, "must not contain duplicate values",
+					map[string]interface{}{"duplicates": duplicates})
+			}
+
+		case "email":
+			if s, ok := indirect(value).Interface().(string); !ok || !Matches(s, EmailRX) {
+				v.AddFieldError(key, CodeFormat, "must be a valid email address",
+					map[string]interface{}{"format": "email"})
+			}
+
+		default:
+			customRulesMu.RLock()
+			custom := customRules[r.name]
+			customRulesMu.RUnlock()
+
+			if !custom.fn(value, r.param) {
+				var params map[string]interface{}
+				if r.param != "" {
+					params = map[string]interface{}{"param": r.param}
+				}
+				v.AddFieldError(key, custom.code, custom.message, params)
+			}
+		}
+	}
+
+	return true
+}
+
+// checkBound applies a min or max rule to the length of a string, slice or map, or to the value
+// of a number.
+func (v *Validator) checkBound(key string, value reflect.Value, r rule) {
+	value = indirect(value)
+	isMin := r.name == "min"
+	limit := strconv.FormatFloat(r.number, 'f', -1, 64)
+	params := map[string]interface{}{r.name: r.number}
+
+	var (
+		n                float64
+		code, minMessage string
+		maxMessage       string
+	)
+
+	switch value.Kind() {
+	case reflect.String:
+		n = float64(len(value.String()))
+		code = CodeMaxLength
+		if isMin {
+			code = CodeMinLength
+		}
+		minMessage = "must be at least " + limit + " bytes long"
+		maxMessage = "must not be more than " + limit + " bytes long"
+
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n = float64(value.Len())
+		code = CodeMaxItems
+		if isMin {
+			code = CodeMinItems
+		}
+		minMessage = "must contain at least " + limit + " items"
+		maxMessage = "must not contain more than " + limit + " items"
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = value.Float()
+
+	default:
+		panic(fmt.Sprintf("validator: %s rule used on unsupported type %s", r.name, value.Type()))
+	}
+
+	if code == "" {
+		code = CodeOutOfRange
+		minMessage = "must be at least " + limit
+		maxMessage = "must not be more than " + limit
+	}
+
+	if isMin && n < r.number {
+		v.AddFieldError(key, code, minMessage, params)
+	}
+
+	if !isMin && n > r.number {
+		v.AddFieldError(key, code, maxMessage, params)
+	}
+}
+
+// getStructMeta returns the cached validation metadata for the struct type, parsing it on first use.
+func getStructMeta(t reflect.Type) *structMeta {
+	if meta, ok := structCache.Load(t); ok {
+		return meta.(*structMeta)
+	}
+
+	meta := &structMeta{}
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		tag := sf.Tag.Get("validate")
+		if tag == "-" {
+			continue
+		}
+
+		field := fieldMeta{index: i, name: fieldName(sf)}
+
+		if tag != "" {
+			field.rules, field.dive = parseTag(t, sf, tag)
+		}
+
+		// Fields without rules are only worth visiting if they may contain nested rules
+		if tag == "" && !isStructType(sf.Type) {
+			continue
+		}
+
+		meta.fields = append(meta.fields, field)
+	}
+
+	actual, _ := structCache.LoadOrStore(t, meta)
+	return actual.(*structMeta)
+}
+
+// parseTag splits a validate tag into its rules, with any rules after "dive" returned separately.
+// An unknown rule or a malformed parameter is a programming error, so it panics.
+func parseTag(t reflect.Type, sf reflect.StructField, tag string) (rules, dive []rule) {
+	current := &rules
+
+	for _, part := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+		r := rule{name: name, param: param}
+
+		switch name {
+		case "dive":
+			dive = []rule{}
+			current = &dive
+			continue
+
+		case "min", "max":
+			n, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				panic(fmt.Sprintf("validator: invalid %s parameter %q on %s.%s", name, param, t, sf.Name))
+			}
+			r.number = n
+
+		case "unique":
+			// Checked against the field's own type, as rules after "dive" apply to the elements
+			if current == &rules {
+				checkUniqueType(t, sf)
+			}
+
+		case "required", "oneof", "email":
+
+		default:
+			customRulesMu.RLock()
+			_, ok := customRules[name]
+			customRulesMu.RUnlock()
+
+			if !ok {
+				panic(fmt.Sprintf("validator: unknown rule %q on %s.%s", name, t, sf.Name))
+			}
+		}
+
+		*current = append(*current, r)
+	}
+
+	return rules, dive
+}
+
+// fieldName returns the name a field is reported under: its json name if it has one
+func fieldName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
+
+func indirect(value reflect.Value) reflect.Value {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return value
+		}
+		value = value.Elem()
+	}
+	return value
+}
+
+func isZero(value reflect.Value) bool {
+	if !value.IsValid() {
+		return true
+	}
+
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	default:
+		return value.IsZero()
+	}
+}
+
+func isStruct(value reflect.Value) bool {
+	return indirect(value).Kind() == reflect.Struct
+}
+
+func isStructType(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+// checkUniqueType panics unless the unique rule is used on a slice or array whose elements can
+// be compared. Interface elements are allowed, as their dynamic values are checked when validating.
+func checkUniqueType(t reflect.Type, sf reflect.StructField) {
+	ft := sf.Type
+	for ft.Kind() == reflect.Pointer {
+		ft = ft.Elem()
+	}
+
+	if ft.Kind() != reflect.Slice && ft.Kind() != reflect.Array {
+		panic(fmt.Sprintf("validator: unique rule used on non-list type %s on %s.%s", ft, t, sf.Name))
+	}
+
+	if elem := ft.Elem(); elem.Kind() != reflect.Interface && !elem.Comparable() {
+		panic(fmt.Sprintf("validator: unique rule used on list of non-comparable type %s on %s.%s", elem, t, sf.Name))
+	}
+}
+
+// duplicateValues returns each value which appears more than once in a slice or array, in the
+// order they are first repeated. Elements which can't be used as map keys (such as slices held in
+// an interface) are compared with reflect.DeepEqual instead.
+func duplicateValues(value reflect.Value) []interface{} {
+	value = indirect(value)
+
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return nil
+	}
+
+	type seenValue struct {
+		value interface{}
+		count int
+	}
+
+	var (
+		duplicates   []interface{}
+		counts       = make(map[interface{}]int, value.Len())
+		incomparable []*seenValue
+	)
+
+	for i := 0; i < value.Len(); i++ {
+		elem := value.Index(i)
+
+		if elem.Comparable() {
+			key := elem.Interface()
+			counts[key]++
+			if counts[key] == 2 {
+				duplicates = append(duplicates, key)
+			}
+			continue
+		}
+
+		current := elem.Interface()
+		found := false
+
+		for _, previous := range incomparable {
+			if reflect.DeepEqual(previous.value, current) {
+				previous.count++
+				if previous.count == 2 {
+					duplicates = append(duplicates, current)
+				}
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			incomparable = append(incomparable, &seenValue{value: current, count: 1})
+		}
+	}
+
+	return duplicates
+}
@@ -0,0 +1,211 @@
+package validator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func init() {
+	RegisterRule("even", CodeInvalid, "must be even", func(value reflect.Value, _ string) bool {
+		return value.Int()%2 == 0
+	})
+}
+
+type testAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type testInput struct {
+	Name     string        `json:"name" validate:"required,min=2,max=5"`
+	Email    string        `json:"email" validate:"email"`
+	Age      int           `json:"age" validate:"min=18,max=130"`
+	Count    int           `json:"count" validate:"even"`
+	Color    string        `json:"color" validate:"oneof=red green"`
+	Tags     []string      `json:"tags" validate:"max=3,unique,dive,required,max=4"`
+	Values   []interface{} `json:"values" validate:"unique"`
+	Address  *testAddress  `json:"address"`
+	Previous []testAddress `json:"previous" validate:"dive"`
+	Ignored  string        `json:"ignored" validate:"-"`
+}
+
+func valid() testInput {
+	return testInput{
+		Name:  "alice",
+		Email: "alice@example.com",
+		Age:   30,
+		Color: "red",
+		Tags:  []string{"a", "b"},
+	}
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*testInput)
+		want   map[string][]string // field => codes
+	}{
+		{
+			name:   "valid",
+			modify: func(in *testInput) {},
+			want:   map[string][]string{},
+		},
+		{
+			name:   "required",
+			modify: func(in *testInput) { in.Name = "" },
+			want:   map[string][]string{"name": {CodeRequired}},
+		},
+		{
+			name:   "string length",
+			modify: func(in *testInput) { in.Name = "toolong" },
+			want:   map[string][]string{"name": {CodeMaxLength}},
+		},
+		{
+			name:   "number range",
+			modify: func(in *testInput) { in.Age = 12 },
+			want:   map[string][]string{"age": {CodeOutOfRange}},
+		},
+		{
+			name:   "zero values skip optional rules",
+			modify: func(in *testInput) { in.Email, in.Age, in.Color = "", 0, "" },
+			want:   map[string][]string{},
+		},
+		{
+			name:   "email",
+			modify: func(in *testInput) { in.Email = "not-an-email" },
+			want:   map[string][]string{"email": {CodeFormat}},
+		},
+		{
+			name:   "oneof",
+			modify: func(in *testInput) { in.Color = "blue" },
+			want:   map[string][]string{"color": {CodeInvalid}},
+		},
+		{
+			name:   "custom rule",
+			modify: func(in *testInput) { in.Count = 3 },
+			want:   map[string][]string{"count": {CodeInvalid}},
+		},
+		{
+			name:   "list rules",
+			modify: func(in *testInput) { in.Tags = []string{"a", "a", "b", "c"} },
+			want:   map[string][]string{"tags": {CodeMaxItems, CodeDuplicate}},
+		},
+		{
+			name:   "dive",
+			modify: func(in *testInput) { in.Tags = []string{"a", "", "toolong"} },
+			want:   map[string][]string{"tags[1]": {CodeRequired}, "tags[2]": {CodeMaxLength}},
+		},
+		{
+			name:   "unique with incomparable elements",
+			modify: func(in *testInput) { in.Values = []interface{}{[]int{1}, 2, []int{1}, []int{1}} },
+			want:   map[string][]string{"values": {CodeDuplicate}},
+		},
+		{
+			name:   "nested struct",
+			modify: func(in *testInput) { in.Address = &testAddress{} },
+			want:   map[string][]string{"address.city": {CodeRequired}},
+		},
+		{
+			name:   "dive into structs",
+			modify: func(in *testInput) { in.Previous = []testAddress{{City: "x"}, {}} },
+			want:   map[string][]string{"previous[1].city": {CodeRequired}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := valid()
+			tt.modify(&input)
+
+			v := New()
+			v.Struct(&input)
+
+			got := make(map[string][]string)
+			for field, fieldErrors := range v.FieldErrors {
+				for _, fieldError := range fieldErrors {
+					got[field] = append(got[field], fieldError.Code)
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got errors %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStructUniqueReportsDuplicates(t *testing.T) {
+	input := valid()
+	input.Tags = []string{"a", "b", "a", "b", "a"}
+
+	v := New()
+	v.Struct(&input)
+
+	for _, fieldError := range v.FieldErrors["tags"] {
+		if fieldError.Code != CodeDuplicate {
+			continue
+		}
+
+		want := []interface{}{"a", "b"}
+		if got := fieldError.Params["duplicates"]; !reflect.DeepEqual(got, want) {
+			t.Errorf("got duplicates %v; want %v", got, want)
+		}
+		return
+	}
+
+	t.Fatalf("no duplicate error in %v", v.FieldErrors)
+}
+
+func TestStructInvalidTags(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		panic string
+	}{
+		{
+			name: "unknown rule",
+			value: &struct {
+				Name string `validate:"bogus"`
+			}{},
+			panic: "unknown rule",
+		},
+		{
+			name: "unique on non-comparable elements",
+			value: &struct {
+				Lists [][]int `validate:"unique"`
+			}{},
+			panic: "non-comparable",
+		},
+		{
+			name: "unique on a map",
+			value: &struct {
+				Counts map[string]int `validate:"unique"`
+			}{},
+			panic: "non-list",
+		},
+		{
+			name: "malformed bound",
+			value: &struct {
+				Name string `validate:"max=ten"`
+			}{},
+			panic: "invalid max parameter",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				err := recover()
+				if err == nil {
+					t.Fatal("expected a panic")
+				}
+
+				if msg, _ := err.(string); !strings.Contains(msg, tt.panic) {
+					t.Errorf("got panic %q; want it to contain %q", msg, tt.panic)
+				}
+			}()
+
+			New().Struct(tt.value)
+		})
+	}
+}
@@ -1,22 +1,51 @@
 package validator
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
 )
 
 var (
 	EmailRX = regexp.MustCompile(`^[a-zA-Z0-9.!#$%&'*+\/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
 )
 
-// Validator holds validation errors. It uses a map where the key is a field name
-// and the value is the error message associated with that field.
+// Stable, machine-readable error codes which clients can use to localize validation messages.
+const (
+	CodeInvalid     = "invalid"
+	CodeRequired    = "required"
+	CodeMinLength   = "min_length"
+	CodeMaxLength   = "max_length"
+	CodeOutOfRange  = "out_of_range"
+	CodeMinItems    = "min_items"
+	CodeMaxItems    = "max_items"
+	CodeDuplicate   = "duplicate"
+	CodeInvalidType = "invalid_type"
+	CodeFormat      = "invalid_format"
+)
+
+// FieldError is a single validation failure for a field. Params holds the values needed to build
+// a localized message (e.g. {"max": 500} for a max_length error).
+type FieldError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+}
+
+// Validator holds validation errors. Errors maps each field name to its first error message,
+// while FieldErrors keeps every error for the field along with its code and parameters.
+// Field names may be nested paths such as "genres[2]" or "address.city".
 type Validator struct {
-	Errors map[string]string
+	Errors      map[string]string
+	FieldErrors map[string][]FieldError
 }
 
-// New creates and returns a new Validator instance with an initialized Errors map.
+// New creates and returns a new Validator instance with initialized error maps.
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{
+		Errors:      make(map[string]string),
+		FieldErrors: make(map[string][]FieldError),
+	}
 }
 
 // Valid returns true if there are no errors in the Errors map, indicating that all validations passed.
@@ -24,11 +53,24 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
-// AddError adds an error message to the Errors map for a given field if the error doesn't already exist.
+// AddError adds an error message with the generic "invalid" code for a given field. The Errors map
+// only keeps the first message for each field.
 func (v *Validator) AddError(key, message string) {
+	v.AddFieldError(key, CodeInvalid, message, nil)
+}
+
+// AddFieldError records an error with the given code and parameters for a field. Every error is
+// kept in FieldErrors, while the Errors map only keeps the first message for each field.
+func (v *Validator) AddFieldError(key, code, message string, params map[string]interface{}) {
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
 	}
+
+	v.FieldErrors[key] = append(v.FieldErrors[key], FieldError{
+		Code:    code,
+		Message: message,
+		Params:  params,
+	})
 }
 
 // Check adds an error message to the Errors map if the given condition `ok` is false.
@@ -38,6 +80,49 @@ func (v *Validator) Check(ok bool, key, message string) {
 	}
 }
 
+// CheckCode records an error with the given code and parameters if the condition `ok` is false.
+func (v *Validator) CheckCode(ok bool, key, code, message string, params map[string]interface{}) {
+	if !ok {
+		v.AddFieldError(key, code, message, params)
+	}
+}
+
+// Index returns the path of the i-th element of a list field, e.g. Index("genres", 2) is "genres[2]".
+func Index(key string, i int) string {
+	return fmt.Sprintf("%s[%d]", key, i)
+}
+
+// Nested returns the path of a field within an object field, e.g. Nested("address", "city") is
+// "address.city".
+func Nested(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// Pointer converts a field path such as "genres[2]" or "address.city" into an RFC 6901 JSON
+// Pointer ("/genres/2", "/address/city").
+func Pointer(path string) string {
+	var b strings.Builder
+
+	for _, part := range strings.Split(path, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+
+		name = strings.ReplaceAll(name, "~", "~0")
+		name = strings.ReplaceAll(name, "/", "~1")
+		b.WriteString("/" + name)
+
+		for rest != "" {
+			index, next, _ := strings.Cut(rest, "]")
+			b.WriteString("/" + index)
+			rest = strings.TrimPrefix(next, "[")
+		}
+	}
+
+	return b.String()
+}
+
 // In checks if a given value is present in a list of strings. It returns true if the value is found.
 func In(value string, list ...string) bool {
 	for _, v := range list {
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    operation text NOT NULL,
+    snapshot jsonb NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    request_id text,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (movie_id, version)
+);
+
+-- Record the current state of existing movies so every movie has a revision to diff and revert against
+INSERT INTO movie_revisions (movie_id, version, operation, snapshot, created_at)
+SELECT id, version, 'snapshot',
+    jsonb_build_object('id', id, 'title', title, 'year', year, 'runtime', runtime, 'genres', genres, 'version', version),
+    created_at
+FROM movies
+ON CONFLICT DO NOTHING;
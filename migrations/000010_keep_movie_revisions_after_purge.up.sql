@@ -0,0 +1,2 @@
+-- Revisions are an audit trail, so they must outlive the movies they describe
+ALTER TABLE movie_revisions DROP CONSTRAINT IF EXISTS movie_revisions_movie_id_fkey;
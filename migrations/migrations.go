@@ -0,0 +1,9 @@
+// Package migrations embeds the SQL migration files so they ship inside the API binary.
+package migrations
+
+import "embed"
+
+// FS holds every *.up.sql and *.down.sql file in this directory
+//
+//go:embed *.sql
+var FS embed.FS